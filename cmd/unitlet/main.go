@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"strings"
 
 	cli "github.com/virtual-kubelet/node-cli"
@@ -9,7 +11,9 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/log"
 
 	"github.com/anqur/unitlet"
+	"github.com/anqur/unitlet/internal/hooks"
 	"github.com/anqur/unitlet/internal/logging"
+	"github.com/anqur/unitlet/pkg/units"
 )
 
 const k8sVersion = "v1.19.10"
@@ -21,6 +25,14 @@ func main() {
 	defer cancel()
 	ctx = cli.ContextWithCancelOnSignal(ctx)
 
+	if len(os.Args) > 1 && os.Args[1] == units.HookHTTPCommand {
+		if err := hooks.RunHTTP(ctx, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	o := opts.New()
 	o.Provider = unitlet.ProviderName
 	o.Version = strings.Join([]string{k8sVersion, unitlet.ProviderName, version}, "-")
@@ -0,0 +1,40 @@
+package execs
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+func (e *Nsenter) Dial(ctx context.Context, pid int, network, addr string) (net.Conn, error) {
+	ns, err := os.Open(filepath.Join(ProcPath, strconv.Itoa(pid), "ns", "net"))
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		// The thread is left locked, so it is thrown away with the goroutine
+		// instead of returning to the scheduler with a foreign namespace.
+		runtime.LockOSThread()
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			ch <- result{nil, err}
+			return
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, addr)
+		ch <- result{conn, err}
+	}()
+	r := <-ch
+	return r.conn, r.err
+}
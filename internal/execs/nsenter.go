@@ -0,0 +1,121 @@
+package execs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+
+	"github.com/anqur/unitlet/internal/ptys"
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+const NsenterBin = "nsenter"
+
+type Nsenter struct{ bin string }
+
+func NewNsenter() (units.Executor, error) {
+	bin, err := exec.LookPath(NsenterBin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errs.ErrNsenterNotFound, err)
+	}
+	return &Nsenter{bin}, nil
+}
+
+func (e *Nsenter) Exec(ctx context.Context, pid int, cmd []string, attach api.AttachIO) error {
+	p, err := readProcess(pid)
+	if err != nil {
+		return err
+	}
+	cgroup, err := os.Open(p.cgroup)
+	if err != nil {
+		return err
+	}
+	defer cgroup.Close()
+
+	args := []string{"--target", strconv.Itoa(pid), "--mount"}
+	for _, ns := range p.namespaces {
+		args = append(args, "--"+ns)
+	}
+	args = append(args, "--wd", "--setuid", p.uid, "--setgid", p.gid, "--")
+	args = append(args, cmd...)
+	c := exec.CommandContext(ctx, e.bin, args...)
+	c.Env = p.env
+	c.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cgroup.Fd())}
+
+	if attach.TTY() {
+		return runTTY(c, attach)
+	}
+	return run(c, attach)
+}
+
+func run(c *exec.Cmd, attach api.AttachIO) error {
+	if stdout := attach.Stdout(); stdout != nil {
+		c.Stdout = stdout
+	}
+	if stderr := attach.Stderr(); stderr != nil {
+		c.Stderr = stderr
+	}
+
+	var w io.WriteCloser
+	stdin := attach.Stdin()
+	if stdin != nil {
+		var err error
+		if w, err = c.StdinPipe(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+	if stdin != nil {
+		go func() {
+			_, _ = io.Copy(w, stdin)
+			_ = w.Close()
+		}()
+	}
+	return c.Wait()
+}
+
+func runTTY(c *exec.Cmd, attach api.AttachIO) error {
+	ptm, pts, err := ptys.Open()
+	if err != nil {
+		return err
+	}
+	defer ptm.Close()
+
+	c.Stdin, c.Stdout, c.Stderr = pts, pts, pts
+	c.SysProcAttr.Setsid = true
+	c.SysProcAttr.Setctty = true
+	err = c.Start()
+	_ = pts.Close()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go resizePty(ptm, attach.Resize(), done)
+
+	if stdin := attach.Stdin(); stdin != nil {
+		go func() { _, _ = io.Copy(ptm, stdin) }()
+	}
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		if stdout := attach.Stdout(); stdout != nil {
+			_, _ = io.Copy(stdout, ptm)
+		}
+	}()
+
+	err = c.Wait()
+	<-copied
+	return err
+}
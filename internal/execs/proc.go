@@ -0,0 +1,99 @@
+package execs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anqur/unitlet/pkg/errs"
+)
+
+const (
+	ProcPath   = "/proc"
+	CgroupPath = "/sys/fs/cgroup"
+
+	SelfPath = "self"
+
+	procUIDKey = "Uid:"
+	procGIDKey = "Gid:"
+
+	unifiedCgroupPrefix = "0::"
+)
+
+var PodNamespaces = []string{"net", "ipc"}
+
+type process struct {
+	uid, gid   string
+	env        []string
+	cgroup     string
+	namespaces []string
+}
+
+func readProcess(pid int) (*process, error) {
+	dir := filepath.Join(ProcPath, strconv.Itoa(pid))
+	p := new(process)
+
+	status, err := os.ReadFile(filepath.Join(dir, "status"))
+	if err != nil {
+		return nil, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(status))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		switch fields[0] {
+		case procUIDKey:
+			p.uid = fields[2]
+		case procGIDKey:
+			p.gid = fields[2]
+		}
+	}
+	if p.uid == "" || p.gid == "" {
+		return nil, fmt.Errorf("%w: pid=%d", errs.ErrBadProcess, pid)
+	}
+
+	environ, err := os.ReadFile(filepath.Join(dir, "environ"))
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range bytes.Split(environ, []byte{0}) {
+		if len(kv) > 0 {
+			p.env = append(p.env, string(kv))
+		}
+	}
+
+	cgroups, err := os.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(cgroups), "\n") {
+		if strings.HasPrefix(line, unifiedCgroupPrefix) {
+			p.cgroup = filepath.Join(CgroupPath, strings.TrimPrefix(line, unifiedCgroupPrefix))
+		}
+	}
+	if p.cgroup == "" {
+		return nil, fmt.Errorf("%w: pid=%d", errs.ErrNoUnifiedCgroup, pid)
+	}
+
+	for _, ns := range PodNamespaces {
+		own, err := os.Readlink(filepath.Join(ProcPath, SelfPath, "ns", ns))
+		if err != nil {
+			return nil, err
+		}
+		target, err := os.Readlink(filepath.Join(dir, "ns", ns))
+		if err != nil {
+			return nil, err
+		}
+		if own != target {
+			p.namespaces = append(p.namespaces, ns)
+		}
+	}
+
+	return p, nil
+}
@@ -0,0 +1,20 @@
+package execs
+
+import (
+	"os"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+
+	"github.com/anqur/unitlet/internal/ptys"
+)
+
+func resizePty(ptm *os.File, sizes <-chan api.TermSize, done <-chan struct{}) {
+	for {
+		select {
+		case size := <-sizes:
+			_ = ptys.Resize(ptm, size)
+		case <-done:
+			return
+		}
+	}
+}
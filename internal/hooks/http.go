@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+type headers []string
+
+func (h *headers) String() string     { return strings.Join(*h, ", ") }
+func (h *headers) Set(v string) error { *h = append(*h, v); return nil }
+
+// RunHTTP performs an HTTP lifecycle hook from the arguments of
+// units.HookHTTPCommand. Like the kubelet, it does not verify the certificate
+// of an HTTPS target, which usually serves a self-signed one on localhost.
+func RunHTTP(ctx context.Context, args []string) error {
+	var hs headers
+	fs := flag.NewFlagSet(units.HookHTTPCommand, flag.ContinueOnError)
+	fs.Var(&hs, units.HookHeaderFlag, "request header in the form of `name: value`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: want one URL, got %q", errs.ErrHookFailed, fs.Args())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fs.Arg(0), nil)
+	if err != nil {
+		return err
+	}
+	for _, h := range hs {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return fmt.Errorf("%w: bad header %q", errs.ErrHookFailed, h)
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: status=%d", errs.ErrHookFailed, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+func TestRunHTTP(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/started" || r.Header.Get("X-Hook") != "post start" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	flag := "-" + units.HookHeaderFlag
+	if err := RunHTTP(ctx, []string{flag, "X-Hook: post start", srv.URL + "/started"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{srv.URL + "/started"},
+		{flag, "X-Hook: post start", srv.URL + "/missing"},
+		{flag, "X-Hook", srv.URL + "/started"},
+		{},
+	} {
+		if err := RunHTTP(ctx, args); !errors.Is(err, errs.ErrHookFailed) {
+			t.Fatal(args, err)
+		}
+	}
+}
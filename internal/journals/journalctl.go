@@ -0,0 +1,176 @@
+package journals
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+const (
+	JournalctlBin = "journalctl"
+
+	JournalMessageKey      = "MESSAGE"
+	JournalRealtimeKey     = "__REALTIME_TIMESTAMP"
+	JournalInvocationIDKey = "_SYSTEMD_INVOCATION_ID"
+)
+
+type Journalctl struct{ bin string }
+
+func NewJournalctl() (units.Journal, error) {
+	bin, err := exec.LookPath(JournalctlBin)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errs.ErrJournalNotFound, err)
+	}
+	return &Journalctl{bin}, nil
+}
+
+func (j *Journalctl) Read(
+	ctx context.Context,
+	name units.Name,
+	invocation string,
+	opts *api.ContainerLogOpts,
+) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, j.bin, args(name, invocation, opts)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("%w: %v", errs.ErrReadJournal, err)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		defer cancel()
+		err := copyEntries(w, stdout, opts.Timestamps)
+		if err != nil {
+			cancel()
+		}
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil && ctx.Err() == nil {
+			err = fmt.Errorf("%w: %v", errs.ErrReadJournal, waitErr)
+		}
+		_ = w.CloseWithError(err)
+	}()
+
+	rc := &cancelReadCloser{r, cancel}
+	if opts.LimitBytes > 0 {
+		return &limitedReadCloser{io.LimitReader(rc, int64(opts.LimitBytes)), rc}, nil
+	}
+	return rc, nil
+}
+
+func args(name units.Name, invocation string, opts *api.ContainerLogOpts) []string {
+	ret := []string{"--output", "json", "--all", "--no-pager", "--quiet"}
+	if invocation != "" {
+		ret = append(ret, JournalInvocationIDKey+"="+invocation)
+	} else {
+		ret = append(ret, "--unit", string(name))
+	}
+	if opts.Tail > 0 {
+		ret = append(ret, "--lines", strconv.Itoa(opts.Tail))
+	} else if opts.Follow {
+		ret = append(ret, "--lines", "all")
+	}
+	if since := sinceTime(opts); !since.IsZero() {
+		ret = append(ret, "--since", fmt.Sprintf("@%d.%06d", since.Unix(), since.Nanosecond()/int(time.Microsecond)))
+	}
+	if opts.Follow {
+		ret = append(ret, "--follow")
+	}
+	return ret
+}
+
+func sinceTime(opts *api.ContainerLogOpts) (ret time.Time) {
+	if opts.SinceSeconds > 0 {
+		ret = time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	}
+	if t := opts.SinceTime; t.After(ret) {
+		ret = t
+	}
+	return
+}
+
+func copyEntries(w io.Writer, r io.Reader, timestamps bool) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := writeEntry(w, line, timestamps); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeEntry(w io.Writer, line []byte, timestamps bool) error {
+	var e map[string]json.RawMessage
+	if err := json.Unmarshal(line, &e); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrReadJournal, err)
+	}
+
+	var buf []byte
+	if timestamps {
+		buf = append(buf, entryTime(e[JournalRealtimeKey]).Format(time.RFC3339Nano)...)
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, entryMessage(e[JournalMessageKey])...)
+	buf = append(buf, '\n')
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func entryMessage(raw json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s)
+	}
+	var ns []int
+	_ = json.Unmarshal(raw, &ns)
+	bs := make([]byte, len(ns))
+	for i, n := range ns {
+		bs[i] = byte(n)
+	}
+	return bs
+}
+
+func entryTime(raw json.RawMessage) time.Time {
+	var s string
+	_ = json.Unmarshal(raw, &s)
+	us, _ := strconv.ParseInt(s, 10, 64)
+	return time.UnixMicro(us).UTC()
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type cancelReadCloser struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReadCloser) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
@@ -0,0 +1,100 @@
+package journals
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+func TestArgs(t *testing.T) {
+	since := time.Unix(1700000000, 123456789)
+	for _, tt := range []struct {
+		name       string
+		invocation string
+		opts       api.ContainerLogOpts
+		want       string
+	}{
+		{
+			name: "unit",
+			want: "--output json --all --no-pager --quiet --unit a.service",
+		},
+		{
+			name:       "invocation",
+			invocation: "abc",
+			opts:       api.ContainerLogOpts{Tail: 10},
+			want:       "--output json --all --no-pager --quiet _SYSTEMD_INVOCATION_ID=abc --lines 10",
+		},
+		{
+			name: "follow since",
+			opts: api.ContainerLogOpts{Follow: true, SinceTime: since},
+			want: "--output json --all --no-pager --quiet --unit a.service --lines all " +
+				"--since @1700000000.123456 --follow",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(args(units.Name("a.service"), tt.invocation, &tt.opts), " ")
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteEntry(t *testing.T) {
+	var buf bytes.Buffer
+	line := []byte(`{"MESSAGE":[104,105],"__REALTIME_TIMESTAMP":"1700000000000001"}`)
+	if err := writeEntry(&buf, line, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "2023-11-14T22:13:20.000001Z hi\n" {
+		t.Fatal(got)
+	}
+}
+
+func TestCloseKillsFollow(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "journalctl")
+	pidFile := filepath.Join(dir, "pid")
+	script := "#!/bin/sh\necho $$ > " + pidFile + "\necho '{\"MESSAGE\":\"hi\"}'\nexec sleep 60\n"
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	j := &Journalctl{bin}
+	r, err := j.Read(context.Background(), "a.service", "", &api.ContainerLogOpts{Follow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := make([]byte, 3)
+	if _, err := io.ReadFull(r, line); err != nil || string(line) != "hi\n" {
+		t.Fatal(string(line), err)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); syscall.Kill(pid, 0) == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("journalctl still running after close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
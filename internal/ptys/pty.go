@@ -0,0 +1,41 @@
+package ptys
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"golang.org/x/sys/unix"
+)
+
+const PtmxPath = "/dev/ptmx"
+
+func Open() (ptm, pts *os.File, err error) {
+	if ptm, err = os.OpenFile(PtmxPath, os.O_RDWR|syscall.O_NOCTTY, 0); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = ptm.Close()
+		}
+	}()
+
+	fd := int(ptm.Fd())
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		return
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		return
+	}
+	pts, err = os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	return
+}
+
+func Resize(ptm *os.File, size api.TermSize) error {
+	return unix.IoctlSetWinsize(int(ptm.Fd()), unix.TIOCSWINSZ, &unix.Winsize{
+		Row: size.Height,
+		Col: size.Width,
+	})
+}
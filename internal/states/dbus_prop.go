@@ -2,6 +2,7 @@ package states
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"strconv"
@@ -18,10 +19,25 @@ import (
 
 const (
 	DbusExitCodeKey     = "ExecMainStatus"
+	DbusExitKindKey     = "ExecMainCode"
 	DbusStartedAtKey    = "ExecMainStartTimestamp"
 	DbusFinishedAtKey   = "ExecMainExitTimestamp"
 	DbusContainerIDKey  = "MainPID"
 	DbusRestartCountKey = "NRestarts"
+	DbusInvocationIDKey = "InvocationID"
+	DbusResultKey       = "Result"
+	DbusStateChangeKey  = "StateChangeTimestamp"
+	DbusRestartNextKey  = "RestartUSecNext"
+	DbusRestartKey      = "RestartUSec"
+
+	DbusResultStartLimitHit = "start-limit-hit"
+	DbusResultOOMKill       = "oom-kill"
+	DbusResultTimeout       = "timeout"
+	DbusResultSignal        = "signal"
+	DbusResultCoreDump      = "core-dump"
+
+	DbusExitKindKilled = 2
+	DbusExitKindDumped = 3
 
 	DbusTerminatedStop   = "stop"
 	DbusTerminatedFailed = "failed"
@@ -38,22 +54,42 @@ const (
 )
 
 type DbusProperties struct {
-	exitCode, restartCount int32
-	startedAt, finishedAt  meta.Time
-	containerID            *url.URL
+	exitCode, signal      int32
+	restartCount          int32
+	startedAt, finishedAt meta.Time
+	containerID           *url.URL
+	invocationID          string
+	result                string
+	stateChangedAt        meta.Time
+	restartDelay          time.Duration
 }
 
 func (p *DbusProperties) ExitCode() int32       { return p.exitCode }
+func (p *DbusProperties) Signal() int32         { return p.signal }
 func (p *DbusProperties) RestartCount() int32   { return p.restartCount }
 func (p *DbusProperties) StartedAt() meta.Time  { return p.startedAt }
 func (p *DbusProperties) FinishedAt() meta.Time { return p.finishedAt }
 func (p *DbusProperties) ContainerID() *url.URL { return p.containerID }
+func (p *DbusProperties) InvocationID() string  { return p.invocationID }
+
+func (p *DbusProperties) Result() string              { return p.result }
+func (p *DbusProperties) StateChangedAt() meta.Time   { return p.stateChangedAt }
+func (p *DbusProperties) RestartDelay() time.Duration { return p.restartDelay }
 
 func (s *DbusState) Properties(ctx context.Context, name units.Name) (units.Properties, error) {
 	exitCode, err := s.getPropertyInt(ctx, name, DbusExitCodeKey)
 	if err != nil {
 		return nil, err
 	}
+	exitKind, err := s.getPropertyInt(ctx, name, DbusExitKindKey)
+	if err != nil {
+		return nil, err
+	}
+	var signal int64
+	if exitKind == DbusExitKindKilled || exitKind == DbusExitKindDumped {
+		signal = exitCode
+		exitCode += 128
+	}
 	restartCount, err := s.getPropertyInt(ctx, name, DbusRestartCountKey)
 	if err != nil {
 		return nil, err
@@ -70,12 +106,36 @@ func (s *DbusState) Properties(ctx context.Context, name units.Name) (units.Prop
 	if err != nil {
 		return nil, err
 	}
+	invocationID, err := s.getPropertyID(ctx, name, DbusInvocationIDKey)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.getPropertyString(ctx, name, DbusResultKey)
+	if err != nil {
+		return nil, err
+	}
+	stateChangedAt, err := s.getUnitPropertyTime(ctx, name, DbusStateChangeKey)
+	if err != nil {
+		return nil, err
+	}
+	restartDelay, err := s.getPropertyInt(ctx, name, DbusRestartNextKey)
+	if err != nil {
+		if restartDelay, err = s.getPropertyInt(ctx, name, DbusRestartKey); err != nil {
+			return nil, err
+		}
+	}
 	return &DbusProperties{
 		exitCode:     int32(exitCode),
+		signal:       int32(signal),
 		restartCount: int32(restartCount),
 		startedAt:    startedAt,
 		finishedAt:   finishedAt,
 		containerID:  containerID,
+		invocationID: invocationID,
+
+		result:         result,
+		stateChangedAt: stateChangedAt,
+		restartDelay:   time.Duration(restartDelay) * time.Microsecond,
 	}, nil
 }
 
@@ -96,7 +156,23 @@ func (s *DbusState) getPropertyTime(ctx context.Context, name units.Name, key st
 	if err != nil {
 		return meta.Time{}, err
 	}
-	return meta.NewTime(parseTimestampMilli(propValue(p))), nil
+	return meta.NewTime(parseTimestampMicro(propValue(p))), nil
+}
+
+func (s *DbusState) getUnitPropertyTime(ctx context.Context, name units.Name, key string) (meta.Time, error) {
+	p, err := s.c.GetUnitPropertyContext(ctx, string(name), key)
+	if err != nil {
+		return meta.Time{}, err
+	}
+	return meta.NewTime(parseTimestampMicro(propValue(p))), nil
+}
+
+func (s *DbusState) getPropertyString(ctx context.Context, name units.Name, key string) (string, error) {
+	p, err := s.c.GetServicePropertyContext(ctx, string(name), key)
+	if err != nil {
+		return "", err
+	}
+	return propValue(p), nil
 }
 
 func (s *DbusState) getPropertyURL(ctx context.Context, name units.Name, key string) (*url.URL, error) {
@@ -107,26 +183,52 @@ func (s *DbusState) getPropertyURL(ctx context.Context, name units.Name, key str
 	return &url.URL{Scheme: "pid", Host: propValue(p)}, nil
 }
 
+func (s *DbusState) getPropertyID(ctx context.Context, name units.Name, key string) (string, error) {
+	p, err := s.c.GetUnitPropertyContext(ctx, string(name), key)
+	if err != nil {
+		return "", err
+	}
+	id, _ := p.Value.Value().([]byte)
+	return hex.EncodeToString(id), nil
+}
+
 func propValue(p *dbus.Property) string { return fmt.Sprintf("%v", p.Value.Value()) }
 
-func parseTimestampMilli(s string) time.Time {
-	ms, _ := strconv.ParseInt(s, 10, 64)
-	return time.UnixMilli(ms)
+func parseTimestampMicro(s string) time.Time {
+	us, _ := strconv.ParseInt(s, 10, 64)
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us)
 }
 
 func toContainerState(subState string, props units.Properties) (ret core.ContainerState) {
+	if subState == DbusRunningAutoRestart {
+		next := props.StateChangedAt().Add(props.RestartDelay())
+		ret.Waiting = &core.ContainerStateWaiting{
+			Reason:  units.ReasonCrashLoopBackOff,
+			Message: fmt.Sprintf("back-off restarting failed container, next restart at %s", next.Format(time.RFC3339)),
+		}
+		return
+	}
+	if subState == DbusTerminatedFailed && props.Result() == DbusResultStartLimitHit {
+		ret.Waiting = &core.ContainerStateWaiting{
+			Reason:  units.ReasonCrashLoopBackOff,
+			Message: "back-off restarting failed container, start limit hit",
+		}
+		return
+	}
+
 	if finishedAt := props.FinishedAt(); strings.HasPrefix(subState, DbusTerminatedStop) ||
 		subState == DbusTerminatedFailed ||
 		subState == DbusTerminatedExited ||
 		(subState == DbusTerminatedDead && !finishedAt.IsZero()) {
-		reason := string(core.PodSucceeded)
-		if props.ExitCode() != 0 {
-			reason = string(core.PodFailed)
-		}
+		reason, message := terminationReason(props)
 		ret.Terminated = &core.ContainerStateTerminated{
 			ExitCode:    props.ExitCode(),
+			Signal:      props.Signal(),
 			Reason:      reason,
-			Message:     reason,
+			Message:     message,
 			StartedAt:   props.StartedAt(),
 			FinishedAt:  finishedAt,
 			ContainerID: props.ContainerID().String(),
@@ -142,7 +244,6 @@ func toContainerState(subState string, props units.Properties) (ret core.Contain
 	}
 
 	if subState == DbusRunning ||
-		subState == DbusRunningAutoRestart ||
 		subState == DbusRunningReload {
 		ret.Running = &core.ContainerStateRunning{StartedAt: props.StartedAt()}
 		return
@@ -151,3 +252,21 @@ func toContainerState(subState string, props units.Properties) (ret core.Contain
 	log.L.Warnf("unknown dbus sub-state %q", subState)
 	return
 }
+
+func terminationReason(props units.Properties) (reason, message string) {
+	switch result := props.Result(); {
+	case result == DbusResultOOMKill:
+		return units.ReasonOOMKilled, "container killed by the OOM killer"
+	case result == DbusResultTimeout:
+		return units.ReasonDeadlineExceeded, "container exceeded its deadline"
+	case result == DbusResultSignal, result == DbusResultCoreDump, props.Signal() != 0:
+		message = fmt.Sprintf("container killed by signal %d", props.Signal())
+		if result == DbusResultCoreDump {
+			message += " (core dumped)"
+		}
+		return units.ReasonError, message
+	case props.ExitCode() != 0:
+		return units.ReasonError, fmt.Sprintf("container exited with code %d", props.ExitCode())
+	}
+	return units.ReasonCompleted, units.ReasonCompleted
+}
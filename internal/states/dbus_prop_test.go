@@ -0,0 +1,91 @@
+package states
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+type fakeProps struct {
+	exitCode, signal int32
+	result           string
+	finishedAt       meta.Time
+}
+
+func (p *fakeProps) ExitCode() int32             { return p.exitCode }
+func (p *fakeProps) Signal() int32               { return p.signal }
+func (p *fakeProps) RestartCount() int32         { return 0 }
+func (p *fakeProps) StartedAt() meta.Time        { return meta.Unix(1, 0) }
+func (p *fakeProps) FinishedAt() meta.Time       { return p.finishedAt }
+func (p *fakeProps) ContainerID() *url.URL       { return &url.URL{Scheme: "pid", Host: "42"} }
+func (p *fakeProps) InvocationID() string        { return "" }
+func (p *fakeProps) Result() string              { return p.result }
+func (p *fakeProps) StateChangedAt() meta.Time   { return meta.Unix(2, 0) }
+func (p *fakeProps) RestartDelay() time.Duration { return 10 * time.Second }
+
+func TestToContainerState(t *testing.T) {
+	finished := meta.Unix(3, 0)
+	for _, tt := range []struct {
+		name     string
+		subState string
+		props    fakeProps
+		state    string
+		reason   string
+	}{
+		{"running", DbusRunning, fakeProps{}, "running", ""},
+		{"reload", DbusRunningReload, fakeProps{}, "running", ""},
+		{"auto restart", DbusRunningAutoRestart, fakeProps{}, "waiting", units.ReasonCrashLoopBackOff},
+		{"start limit", DbusTerminatedFailed, fakeProps{result: DbusResultStartLimitHit}, "waiting", units.ReasonCrashLoopBackOff},
+		{"starting", "start-pre", fakeProps{}, "waiting", "start-pre"},
+		{"condition", DbusWaitingCondition, fakeProps{}, "waiting", DbusWaitingCondition},
+		{"never started", DbusTerminatedDead, fakeProps{}, "waiting", DbusWaitingDead},
+		{"dead", DbusTerminatedDead, fakeProps{finishedAt: finished}, "terminated", units.ReasonCompleted},
+		{"exited", DbusTerminatedExited, fakeProps{}, "terminated", units.ReasonCompleted},
+		{"stopping", "stop-sigterm", fakeProps{}, "terminated", units.ReasonCompleted},
+		{"exit code", DbusTerminatedFailed, fakeProps{exitCode: 1}, "terminated", units.ReasonError},
+		{"signal", DbusTerminatedFailed, fakeProps{exitCode: 137, signal: 9, result: DbusResultSignal}, "terminated", units.ReasonError},
+		{"oom", DbusTerminatedFailed, fakeProps{exitCode: 137, signal: 9, result: DbusResultOOMKill}, "terminated", units.ReasonOOMKilled},
+		{"timeout", DbusTerminatedFailed, fakeProps{exitCode: 137, signal: 9, result: DbusResultTimeout}, "terminated", units.ReasonDeadlineExceeded},
+		{"unknown", "bogus", fakeProps{}, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := toContainerState(tt.subState, &tt.props)
+			state, reason := containerState(&s)
+			if state != tt.state || reason != tt.reason {
+				t.Fatalf("%s: %s/%s, want %s/%s", tt.subState, state, reason, tt.state, tt.reason)
+			}
+			if s.Terminated != nil &&
+				(s.Terminated.ExitCode != tt.props.exitCode ||
+					s.Terminated.Signal != tt.props.signal ||
+					s.Terminated.ContainerID != "pid://42") {
+				t.Fatal(s.Terminated)
+			}
+		})
+	}
+}
+
+func containerState(s *core.ContainerState) (state, reason string) {
+	switch {
+	case s.Running != nil:
+		return "running", ""
+	case s.Waiting != nil:
+		return "waiting", s.Waiting.Reason
+	case s.Terminated != nil:
+		return "terminated", s.Terminated.Reason
+	}
+	return "", ""
+}
+
+func TestParseTimestampMicro(t *testing.T) {
+	if ts := parseTimestampMicro("0"); !ts.IsZero() {
+		t.Fatal(ts)
+	}
+	if ts := parseTimestampMicro("1700000000000001"); !ts.Equal(time.Unix(1700000000, 1000)) {
+		t.Fatal(ts)
+	}
+}
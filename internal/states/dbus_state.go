@@ -2,18 +2,33 @@ package states
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coreos/go-systemd/v22/dbus"
 	"github.com/coreos/go-systemd/v22/util"
+	godbus "github.com/godbus/dbus/v5"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/anqur/unitlet/pkg/errs"
 	"github.com/anqur/unitlet/pkg/units"
 )
 
-const DbusModeReplace = "replace"
+const (
+	DbusModeReplace = "replace"
+	DbusJobDone     = "done"
+
+	DbusWatchBuffer = 64
+
+	DbusCPUQuotaKey    = "CPUQuotaPerSecUSec"
+	DbusTimeoutStopKey = "TimeoutStopUSec"
+	DbusUnset          = math.MaxUint64
+)
 
 type DbusState struct{ c *dbus.Conn }
 
@@ -55,10 +70,58 @@ func (s *DbusState) Start(ctx context.Context, name units.Name) error {
 }
 
 func (s *DbusState) Stop(ctx context.Context, name units.Name) error {
-	_, err := s.c.StopUnitContext(ctx, string(name), DbusModeReplace, nil)
+	ch := make(chan string, 1)
+	if _, err := s.c.StopUnitContext(ctx, string(name), DbusModeReplace, ch); err != nil {
+		return err
+	}
+	select {
+	case result := <-ch:
+		if result != DbusJobDone {
+			return fmt.Errorf("%w: %s: %s", errs.ErrDbusStop, name, result)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *DbusState) Restart(ctx context.Context, name units.Name) error {
+	_, err := s.c.RestartUnitContext(ctx, string(name), DbusModeReplace, nil)
 	return err
 }
 
+func (s *DbusState) Kill(ctx context.Context, name units.Name, signal syscall.Signal) error {
+	return s.c.KillUnitWithTarget(ctx, string(name), dbus.All, int32(signal))
+}
+
+// SetCgroup applies the limits to a running unit, an absent limit is reset to
+// the systemd default.
+func (s *DbusState) SetCgroup(ctx context.Context, name units.Name, g *units.Cgroup) error {
+	value := func(n *int64, scale int64, unset uint64) godbus.Variant {
+		if n == nil {
+			return godbus.MakeVariant(unset)
+		}
+		return godbus.MakeVariant(uint64(*n * scale))
+	}
+	return s.c.SetUnitPropertiesContext(
+		ctx,
+		string(name),
+		true,
+		dbus.Property{Name: DbusCPUQuotaKey, Value: value(g.CPUQuota, 10000, DbusUnset)},
+		dbus.Property{Name: units.CPUWeightKey, Value: value(g.CPUWeight, 1, DbusUnset)},
+		dbus.Property{Name: units.MemoryMaxKey, Value: value(g.MemoryMax, 1, DbusUnset)},
+		dbus.Property{Name: units.MemoryLowKey, Value: value(g.MemoryLow, 1, 0)},
+		dbus.Property{Name: units.TasksMaxKey, Value: value(g.TasksMax, 1, DbusUnset)},
+	)
+}
+
+func (s *DbusState) SetStopTimeout(ctx context.Context, name units.Name, timeout time.Duration) error {
+	return s.c.SetUnitPropertiesContext(ctx, string(name), true, dbus.Property{
+		Name:  DbusTimeoutStopKey,
+		Value: godbus.MakeVariant(uint64(timeout / time.Microsecond)),
+	})
+}
+
 func (s *DbusState) Reload(ctx context.Context) error { return s.c.ReloadContext(ctx) }
 
 func (s *DbusState) ResetFailed(ctx context.Context, name units.Name) error {
@@ -72,6 +135,7 @@ func (s *DbusState) Views(ctx context.Context) (units.Views, error) {
 	}
 
 	namespaces := make(units.Views)
+	changedAt := make(map[*units.View]meta.Time)
 	for _, info := range infos {
 		ns := info.id.Namespace()
 		pod := info.id.Pod()
@@ -86,28 +150,34 @@ func (s *DbusState) Views(ctx context.Context) (units.Views, error) {
 			namespaces[ns] = pods
 		}
 		if view, ok = pods[pod]; !ok {
-			startedAt := info.props.StartedAt()
-			view = &units.View{
-				Lead: name,
-				Status: &core.PodStatus{
-					Conditions: []core.PodCondition{
-						{Type: core.PodReady, Status: core.ConditionTrue, LastTransitionTime: startedAt},
-						{Type: core.PodInitialized, Status: core.ConditionTrue, LastTransitionTime: startedAt},
-						{Type: core.PodScheduled, Status: core.ConditionTrue, LastTransitionTime: startedAt},
-					},
-				},
-			}
+			view = &units.View{Lead: name, Status: new(core.PodStatus)}
 			pods[pod] = view
 		}
 		view.Names = append(view.Names, name)
+		view.Invocations = append(view.Invocations, info.props.InvocationID())
+		if startedAt := info.props.StartedAt(); !startedAt.IsZero() &&
+			(view.Status.StartTime == nil || startedAt.Before(view.Status.StartTime)) {
+			view.Status.StartTime = &startedAt
+		}
+		if at := info.props.StateChangedAt(); at.After(changedAt[view].Time) {
+			changedAt[view] = at
+		}
+		if info.id.IsInit() {
+			view.Status.InitContainerStatuses = append(view.Status.InitContainerStatuses, info.status)
+			continue
+		}
+		if lead, _ := units.ParseName(view.Lead); lead.IsInit() {
+			view.Lead = name
+		}
 		view.Status.ContainerStatuses = append(view.Status.ContainerStatuses, info.status)
 	}
 
 	for _, pods := range namespaces {
 		for _, view := range pods {
-			phase := units.ReduceContainerStatuses(view.Status.ContainerStatuses)
+			phase := units.ReducePodStatus(view.Status)
 			view.Status.Phase = phase
 			view.Status.Message = string(phase)
+			units.SetPodConditions(view.Status, changedAt[view])
 		}
 	}
 
@@ -128,10 +198,7 @@ func (s *DbusState) listUnits(ctx context.Context) ([]*unitStatus, error) {
 
 	var ret []*unitStatus
 	for _, u := range us {
-		if !strings.HasPrefix(u.Name, units.Prefix) {
-			continue
-		}
-		if !strings.HasSuffix(u.Name, units.Suffix) {
+		if !isPodUnit(u.Name) {
 			continue
 		}
 
@@ -157,3 +224,65 @@ func (s *DbusState) listUnits(ctx context.Context) ([]*unitStatus, error) {
 	}
 	return ret, nil
 }
+
+func isPodUnit(name string) bool {
+	return strings.HasPrefix(name, units.Prefix) && strings.HasSuffix(name, units.Suffix)
+}
+
+func (s *DbusState) WatchInvocations(ctx context.Context) (<-chan units.Invocation, error) {
+	if err := s.c.Subscribe(); err != nil {
+		return nil, err
+	}
+	updates := make(chan *dbus.PropertiesUpdate, DbusWatchBuffer)
+	errCh := make(chan error, DbusWatchBuffer)
+	s.c.SetPropertiesSubscriber(updates, errCh)
+
+	ret := make(chan units.Invocation)
+	go func() {
+		defer close(ret)
+		defer s.unsubscribeProperties(updates, errCh)
+		for {
+			select {
+			case u := <-updates:
+				if !isPodUnit(u.UnitName) {
+					continue
+				}
+				v, ok := u.Changed[DbusInvocationIDKey]
+				if !ok {
+					continue
+				}
+				id, _ := v.Value().([]byte)
+				if len(id) == 0 {
+					continue
+				}
+				select {
+				case ret <- units.Invocation{Name: units.Name(u.UnitName), ID: hex.EncodeToString(id)}:
+				case <-ctx.Done():
+					return
+				}
+			case <-errCh:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ret, nil
+}
+
+// unsubscribeProperties keeps draining while detaching, since the D-Bus
+// dispatcher blocks on a full channel with the subscriber lock held.
+func (s *DbusState) unsubscribeProperties(updates chan *dbus.PropertiesUpdate, errCh chan error) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-updates:
+			case <-errCh:
+			case <-done:
+				return
+			}
+		}
+	}()
+	s.c.SetPropertiesSubscriber(nil, nil)
+	close(done)
+}
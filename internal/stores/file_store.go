@@ -1,26 +1,73 @@
 package stores
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/anqur/unitlet/pkg/errs"
 	"github.com/anqur/unitlet/pkg/units"
 )
 
-const DefaultFileStorePath = "/opt/unitlet/units"
+const (
+	DefaultFileStorePath = "/opt/unitlet/units"
+
+	InvocationsSuffix = ".invocations"
+	MaxInvocations    = 5
+
+	StdinSuffix = ".stdin"
+	TTYSuffix   = ".tty"
+
+	CredentialsDir = ".credentials"
+)
 
 type FileStore struct {
-	path string
+	mu     sync.Mutex
+	path   string
+	stdins map[units.Name]*os.File
+	ttys   map[units.Name]*os.File
+
+	volumesMu sync.Mutex
 }
 
 func NewFileStore(path string) (units.Store, error) {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return nil, err
 	}
-	return &FileStore{path}, nil
+	s := &FileStore{
+		path:   path,
+		stdins: make(map[units.Name]*os.File),
+		ttys:   make(map[units.Name]*os.File),
+	}
+
+	fifos, err := filepath.Glob(filepath.Join(path, "*"+StdinSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, fifo := range fifos {
+		name := units.Name(strings.TrimSuffix(filepath.Base(fifo), StdinSuffix))
+		if err := s.holdStdin(name); err != nil {
+			return nil, err
+		}
+	}
+
+	links, err := filepath.Glob(filepath.Join(path, "*"+TTYSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		name := units.Name(strings.TrimSuffix(filepath.Base(link), TTYSuffix))
+		if err := s.holdTTY(name); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
 }
 
 func (s *FileStore) Location(name units.Name) units.Location {
@@ -33,7 +80,10 @@ func (s *FileStore) GetUnit(_ context.Context, name units.Name) (*units.Unit, er
 		return nil, err
 	}
 	ret := new(units.Unit)
-	return ret, ret.Unmarshal(data)
+	if err := ret.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return ret, readCredentials(ret)
 }
 
 func (s *FileStore) CreateUnits(ctx context.Context, us []*units.Unit) error {
@@ -41,6 +91,18 @@ func (s *FileStore) CreateUnits(ctx context.Context, us []*units.Unit) error {
 }
 
 func (s *FileStore) DeleteUnit(_ context.Context, name units.Name) error {
+	if err := s.releaseStdin(name); err != nil {
+		return err
+	}
+	if err := s.releaseTTY(name); err != nil {
+		return err
+	}
+	if err := os.Remove(s.invocationsPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.RemoveAll(s.credentialsPath(name)); err != nil {
+		return err
+	}
 	return os.Remove(s.filepath(name))
 }
 
@@ -48,28 +110,244 @@ func (s *FileStore) UpdateUnits(ctx context.Context, us []*units.Unit) error {
 	return s.writeUnits(ctx, us, true)
 }
 
+func (s *FileStore) CreatePodUnits(_ context.Context, p *units.PodUnits) error {
+	if err := s.writeVolumes(p); err != nil {
+		return err
+	}
+	if err := s.writeFile(p.SliceName(), p.MarshalSlice, false); err != nil {
+		return err
+	}
+	if err := s.writeFile(p.TargetName(), p.MarshalTarget, false); err != nil {
+		_ = os.Remove(s.filepath(p.SliceName()))
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) UpdatePodSlice(_ context.Context, p *units.PodUnits) (bool, error) {
+	data, err := p.MarshalSlice()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", errs.ErrMarshalUnitFile, err)
+	}
+	old, err := os.ReadFile(s.filepath(p.SliceName()))
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(old, data) {
+		return false, nil
+	}
+	if err := os.WriteFile(s.filepath(p.SliceName()), data, 0644); err != nil {
+		return false, fmt.Errorf("%w: %v", errs.ErrWriteUnitFile, err)
+	}
+	return true, nil
+}
+
+func (s *FileStore) GetInvocations(_ context.Context, name units.Name) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.readInvocations(name)
+}
+
+func (s *FileStore) PutInvocation(_ context.Context, name units.Name, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.readInvocations(name)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 && ids[len(ids)-1] == id {
+		return nil
+	}
+	ids = append(ids, id)
+	if n := len(ids) - MaxInvocations; n > 0 {
+		ids = ids[n:]
+	}
+
+	data := []byte(strings.Join(ids, "\n") + "\n")
+	if err := os.WriteFile(s.invocationsPath(name), data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteInvocations, err)
+	}
+	return nil
+}
+
+func (s *FileStore) OpenStdin(_ context.Context, name units.Name) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.stdins[name]; !ok {
+		return nil, fmt.Errorf("%w: %s", errs.ErrNoStdin, name)
+	}
+	return os.OpenFile(s.stdinPath(name), os.O_WRONLY, 0)
+}
+
 func (s *FileStore) filepath(name units.Name) string {
 	return filepath.Join(s.path, string(name))
 }
 
+func (s *FileStore) invocationsPath(name units.Name) string {
+	return s.filepath(name) + InvocationsSuffix
+}
+
+func (s *FileStore) credentialsPath(name units.Name) string {
+	return filepath.Join(s.path, CredentialsDir, string(name))
+}
+
+func (s *FileStore) writeCredentials(u *units.Unit) error {
+	path := s.credentialsPath(u.ID.Name())
+	if len(u.Credentials) == 0 {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("%w: %v", errs.ErrWriteCredentials, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteCredentials, err)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteCredentials, err)
+	}
+	ids := make(map[string]bool, len(u.Credentials))
+	for _, c := range u.Credentials {
+		ids[c.ID] = true
+		if err := os.WriteFile(filepath.Join(path, c.ID), c.Data, 0600); err != nil {
+			return fmt.Errorf("%w: %v", errs.ErrWriteCredentials, err)
+		}
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteCredentials, err)
+	}
+	for _, e := range entries {
+		if ids[e.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(path, e.Name())); err != nil {
+			return fmt.Errorf("%w: %v", errs.ErrWriteCredentials, err)
+		}
+	}
+	u.CredentialsPath = path
+	return nil
+}
+
+func readCredentials(u *units.Unit) error {
+	for i := range u.Credentials {
+		c := &u.Credentials[i]
+		data, err := os.ReadFile(filepath.Join(u.CredentialsPath, c.ID))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.Data = data
+	}
+	return nil
+}
+
+func (s *FileStore) stdinPath(name units.Name) string {
+	return s.filepath(name) + StdinSuffix
+}
+
+func (s *FileStore) createStdin(name units.Name) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.stdins[name]; ok {
+		return nil
+	}
+	if err := syscall.Mkfifo(s.stdinPath(name), 0600); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("%w: %v", errs.ErrCreateStdin, err)
+	}
+	return s.holdStdin(name)
+}
+
+func (s *FileStore) holdStdin(name units.Name) error {
+	f, err := os.OpenFile(s.stdinPath(name), os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrCreateStdin, err)
+	}
+	s.stdins[name] = f
+	return nil
+}
+
+func (s *FileStore) releaseStdin(name units.Name) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.stdins[name]
+	if !ok {
+		return nil
+	}
+	delete(s.stdins, name)
+	_ = f.Close()
+	if err := os.Remove(s.stdinPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) readInvocations(name units.Name) ([]string, error) {
+	data, err := os.ReadFile(s.invocationsPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
 func (s *FileStore) writeUnits(_ context.Context, us []*units.Unit, overwrite bool) error {
 	for _, u := range us {
-		path := s.filepath(u.ID.Name())
-
 		if !overwrite {
-			if _, err := os.Stat(path); err == nil {
+			if path := s.filepath(u.ID.Name()); fileExists(path) {
 				return fmt.Errorf("%w: %s", errs.ErrUnitFileExists, path)
 			}
 		}
 
-		data, err := u.Marshal()
-		if err != nil {
-			return fmt.Errorf("%w: %v", errs.ErrMarshalUnitFile, err)
+		if u.TTY {
+			if err := s.createTTY(u.ID.Name()); err != nil {
+				return err
+			}
+			u.TTYPath = s.ttyPath(u.ID.Name())
+		} else if u.Stdin {
+			if err := s.createStdin(u.ID.Name()); err != nil {
+				return err
+			}
+			u.StdinPath = s.stdinPath(u.ID.Name())
+		}
+		if err := s.writeCredentials(u); err != nil {
+			return err
+		}
+		if len(u.Mounts) > 0 {
+			u.VolumesPath = s.volumesPath(u.ID.Namespace(), u.ID.Pod())
 		}
 
-		if err := os.WriteFile(path, data, 0644); err != nil {
-			return fmt.Errorf("%w: %v", errs.ErrWriteUnitFile, err)
+		if err := s.writeFile(u.ID.Name(), u.Marshal, true); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+func (s *FileStore) writeFile(name units.Name, marshal func() ([]byte, error), overwrite bool) error {
+	path := s.filepath(name)
+	if !overwrite && fileExists(path) {
+		return fmt.Errorf("%w: %s", errs.ErrUnitFileExists, path)
+	}
+	data, err := marshal()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrMarshalUnitFile, err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteUnitFile, err)
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
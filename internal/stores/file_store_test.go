@@ -0,0 +1,101 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &units.Unit{
+		ID:     units.NewID("a", "b", "c"),
+		Cmd:    []string{"env"},
+		PodUID: "d",
+		Credentials: []units.Credential{
+			{ID: "env.A", Data: []byte("a")},
+			{ID: "env.B", Data: []byte("b")},
+		},
+	}
+	if err := s.CreateUnits(ctx, []*units.Unit{u}); err != nil {
+		t.Fatal(err)
+	}
+	path := u.CredentialsPath
+	got, err := s.GetUnit(ctx, u.ID.Name())
+	if err != nil || string(got.Credentials[1].Data) != "b" || !got.Equal(u) {
+		t.Fatal(got, err)
+	}
+	if info, err := os.Stat(filepath.Join(path, "env.B")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatal(info, err)
+	}
+	u.Credentials[1].Data = []byte("c")
+	if got.Equal(u) {
+		t.Fatal(got)
+	}
+
+	u.Credentials = u.Credentials[:1]
+	if err := s.UpdateUnits(ctx, []*units.Unit{u}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "env.A")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "env.B")); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	u.Credentials = nil
+	if err := s.UpdateUnits(ctx, []*units.Unit{u}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+func TestPodSlice(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := s.(*FileStore).path
+	max := int64(100)
+	om := &meta.ObjectMeta{Namespace: "a", Name: "b"}
+	us := []*units.Unit{{ID: units.NewID("a", "b", "c"), Cgroup: units.Cgroup{MemoryMax: &max}}}
+	p := units.NewPodUnits(om, us)
+	if err := os.WriteFile(filepath.Join(path, string(p.TargetName())), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreatePodUnits(ctx, p); !errors.Is(err, errs.ErrUnitFileExists) {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(path, string(p.SliceName()))); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	_ = os.Remove(filepath.Join(path, string(p.TargetName())))
+	if err := s.CreatePodUnits(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := s.UpdatePodSlice(ctx, p); err != nil || changed {
+		t.Fatal(changed, err)
+	}
+	max = 200
+	if changed, err := s.UpdatePodSlice(ctx, units.NewPodUnits(om, us)); err != nil || !changed {
+		t.Fatal(changed, err)
+	}
+}
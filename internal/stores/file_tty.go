@@ -0,0 +1,94 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	"golang.org/x/sys/unix"
+
+	"github.com/anqur/unitlet/internal/ptys"
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+type terminal struct{ *os.File }
+
+func (t *terminal) Resize(size api.TermSize) error { return ptys.Resize(t.File, size) }
+
+func (s *FileStore) OpenTTY(_ context.Context, name units.Name) (units.Terminal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ptm, ok := s.ttys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errs.ErrNoTTY, name)
+	}
+	// A non-blocking duplicate lets Close interrupt a pending Read without
+	// releasing the master the store holds.
+	fd, err := unix.Dup(int(ptm.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return &terminal{os.NewFile(uintptr(fd), ptm.Name())}, nil
+}
+
+func (s *FileStore) ttyPath(name units.Name) string {
+	return s.filepath(name) + TTYSuffix
+}
+
+func (s *FileStore) createTTY(name units.Name) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ttys[name]; ok {
+		return nil
+	}
+	return s.holdTTY(name)
+}
+
+// holdTTY allocates a terminal and points the unit's TTYPath link to its
+// slave. A master does not survive a restart of unitlet, so the link of an
+// existing unit is repointed and takes effect on the next start of the unit,
+// the provider fails the units still running on the old terminal.
+func (s *FileStore) holdTTY(name units.Name) error {
+	ptm, pts, err := ptys.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrCreateTTY, err)
+	}
+	_ = pts.Close()
+
+	link := s.ttyPath(name)
+	tmp := link + ".tmp"
+	_ = os.Remove(tmp)
+	if err = os.Symlink(pts.Name(), tmp); err == nil {
+		err = os.Rename(tmp, link)
+	}
+	if err != nil {
+		_ = ptm.Close()
+		return fmt.Errorf("%w: %v", errs.ErrCreateTTY, err)
+	}
+	s.ttys[name] = ptm
+	return nil
+}
+
+func (s *FileStore) releaseTTY(name units.Name) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ptm, ok := s.ttys[name]
+	if !ok {
+		return nil
+	}
+	delete(s.ttys, name)
+	_ = ptm.Close()
+	if err := os.Remove(s.ttyPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
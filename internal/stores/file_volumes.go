@@ -0,0 +1,329 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	core "k8s.io/api/core/v1"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+const (
+	VolumesDir = ".volumes"
+
+	EmptyDirMode = 0777
+	// SecretDirMode keeps secrets read-only but traversable by any container
+	// user, like the kubelet, and the file modes decide who reads them. The
+	// pod volumes directory itself is private to root.
+	SecretDirMode    = 0755
+	HostPathDirMode  = 0755
+	HostPathFileMode = 0644
+
+	DataDir        = units.ProjectedDataDir
+	DataTmpDir     = "..data_tmp"
+	DataDirPattern = "..2006_01_02_15_04_05."
+	HiddenPrefix   = ".."
+)
+
+func (s *FileStore) UpdatePodVolumes(_ context.Context, p *units.PodUnits) (changed []string, err error) {
+	s.volumesMu.Lock()
+	defer s.volumesMu.Unlock()
+
+	path := s.volumesPath(p.Namespace(), p.Pod())
+	for _, v := range p.Volumes {
+		dir := filepath.Join(path, v.Name)
+		if !fileExists(dir) {
+			continue
+		}
+		ok, err := writeProjected(dir, v)
+		if err != nil {
+			return changed, fmt.Errorf("%w: %s: %v", errs.ErrWriteVolume, v.Name, err)
+		}
+		if ok {
+			changed = append(changed, v.Name)
+		}
+	}
+	return
+}
+
+func (s *FileStore) DeletePodVolumes(_ context.Context, namespace, pod string) error {
+	s.volumesMu.Lock()
+	defer s.volumesMu.Unlock()
+
+	path := s.volumesPath(namespace, pod)
+	dirs, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		dir := filepath.Join(path, d.Name())
+		if isMountPoint(dir) {
+			if err := unix.Unmount(dir, 0); err != nil {
+				return fmt.Errorf("%w: %s: %v", errs.ErrUnmountVolume, dir, err)
+			}
+		}
+	}
+	return os.RemoveAll(path)
+}
+
+func (s *FileStore) volumesPath(namespace, pod string) string {
+	return filepath.Join(s.path, VolumesDir, namespace+units.Sep+pod)
+}
+
+func (s *FileStore) writeVolumes(p *units.PodUnits) error {
+	if len(p.Volumes) == 0 {
+		return nil
+	}
+	s.volumesMu.Lock()
+	defer s.volumesMu.Unlock()
+
+	path := s.volumesPath(p.Namespace(), p.Pod())
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteVolume, err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("%w: %v", errs.ErrWriteVolume, err)
+	}
+	for _, v := range p.Volumes {
+		if v.HostPath != "" {
+			if err := checkHostPath(v.HostPath, v.HostPathType); err != nil {
+				return fmt.Errorf("%w: %s: %v", errs.ErrBadHostPath, v.Name, err)
+			}
+			continue
+		}
+		if err := writeVolume(filepath.Join(path, v.Name), v); err != nil {
+			return fmt.Errorf("%w: %s: %v", errs.ErrWriteVolume, v.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) VolumeUsage(_ context.Context, namespace, pod, volume string) (int64, error) {
+	var ret int64
+	err := filepath.WalkDir(filepath.Join(s.volumesPath(namespace, pod), volume), func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			ret += st.Blocks * 512
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func checkHostPath(path string, t core.HostPathType) error {
+	switch t {
+	case core.HostPathUnset:
+		return nil
+	case core.HostPathDirectoryOrCreate:
+		if err := os.MkdirAll(path, HostPathDirMode); err != nil {
+			return err
+		}
+	case core.HostPathFileOrCreate:
+		f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, HostPathFileMode)
+		if err != nil {
+			return err
+		}
+		_ = f.Close()
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	mode := info.Mode()
+	var ok bool
+	switch t {
+	case core.HostPathDirectory, core.HostPathDirectoryOrCreate:
+		ok = mode.IsDir()
+	case core.HostPathFile, core.HostPathFileOrCreate:
+		ok = mode.IsRegular()
+	case core.HostPathSocket:
+		ok = mode&os.ModeSocket != 0
+	case core.HostPathCharDev:
+		ok = mode&os.ModeCharDevice != 0
+	case core.HostPathBlockDev:
+		ok = mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+	default:
+		return fmt.Errorf("unknown type %q", t)
+	}
+	if !ok {
+		return fmt.Errorf("%s is not of type %s", path, t)
+	}
+	return nil
+}
+
+func writeVolume(dir string, v *units.Volume) error {
+	mode := os.FileMode(EmptyDirMode)
+	if v.Secret {
+		mode = SecretDirMode
+	}
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dir, mode); err != nil {
+		return err
+	}
+	if v.Memory && !isMountPoint(dir) {
+		opts := fmt.Sprintf("mode=%o", mode)
+		if v.SizeLimit != nil {
+			opts += fmt.Sprintf(",size=%d", v.SizeLimit.Value())
+		}
+		if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, opts); err != nil {
+			return err
+		}
+	}
+	if gid := v.GID; gid != nil {
+		if err := os.Chown(dir, -1, int(*gid)); err != nil {
+			return err
+		}
+		if err := os.Chmod(dir, mode|os.ModeSetgid); err != nil {
+			return err
+		}
+	}
+	if v.Projected {
+		_, err := writeProjected(dir, v)
+		return err
+	}
+	return nil
+}
+
+func writeProjected(dir string, v *units.Volume) (bool, error) {
+	files := v.Files
+	if projectedEqual(dir, files) {
+		return false, nil
+	}
+
+	ts, err := os.MkdirTemp(dir, time.Now().UTC().Format(DataDirPattern))
+	if err != nil {
+		return false, err
+	}
+	if err := writeFiles(ts, files, v.GID); err != nil {
+		_ = os.RemoveAll(ts)
+		return false, err
+	}
+
+	old, _ := os.Readlink(filepath.Join(dir, DataDir))
+	tmp := filepath.Join(dir, DataTmpDir)
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := os.Symlink(filepath.Base(ts), tmp); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, DataDir)); err != nil {
+		return false, err
+	}
+
+	tops := make(map[string]bool)
+	for _, f := range files {
+		top := strings.SplitN(filepath.Clean(f.Path), string(filepath.Separator), 2)[0]
+		tops[top] = true
+		link := filepath.Join(dir, top)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(DataDir, top), link); err != nil {
+			return false, err
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), HiddenPrefix) || tops[e.Name()] || e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return false, err
+		}
+	}
+
+	if old != "" {
+		if err := os.RemoveAll(filepath.Join(dir, old)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func writeFiles(dir string, files []units.VolumeFile, gid *int64) error {
+	if err := os.Chmod(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, f.Data, f.Mode); err != nil {
+			return err
+		}
+		if err := os.Chmod(path, f.Mode); err != nil {
+			return err
+		}
+	}
+	if gid == nil {
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, _ os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, -1, int(*gid))
+	})
+}
+
+func projectedEqual(dir string, files []units.VolumeFile) bool {
+	data := filepath.Join(dir, DataDir)
+	if _, err := os.Stat(data); err != nil {
+		return false
+	}
+	n := 0
+	err := filepath.WalkDir(data+string(filepath.Separator), func(_ string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil || n != len(files) {
+		return false
+	}
+	for _, f := range files {
+		path := filepath.Join(data, f.Path)
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != f.Mode.Perm() {
+			return false
+		}
+		content, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(content, f.Data) {
+			return false
+		}
+	}
+	return true
+}
+
+func isMountPoint(dir string) bool {
+	var st, parent unix.Stat_t
+	if unix.Stat(dir, &st) != nil || unix.Stat(filepath.Dir(dir), &parent) != nil {
+		return false
+	}
+	return st.Dev != parent.Dev
+}
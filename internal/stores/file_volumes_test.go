@@ -0,0 +1,138 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+func TestCheckHostPath(t *testing.T) {
+	root := t.TempDir()
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path string
+		t    core.HostPathType
+		ok   bool
+	}{
+		{filepath.Join(root, "missing"), core.HostPathUnset, true},
+		{root, core.HostPathDirectory, true},
+		{file, core.HostPathDirectory, false},
+		{filepath.Join(root, "missing"), core.HostPathDirectory, false},
+		{filepath.Join(root, "a/b"), core.HostPathDirectoryOrCreate, true},
+		{file, core.HostPathFile, true},
+		{root, core.HostPathFile, false},
+		{filepath.Join(root, "new"), core.HostPathFileOrCreate, true},
+		{root, core.HostPathFileOrCreate, false},
+		{file, core.HostPathSocket, false},
+		{"/dev/null", core.HostPathCharDev, true},
+		{"/dev/null", core.HostPathBlockDev, false},
+		{file, "Bogus", false},
+	} {
+		if err := checkHostPath(tt.path, tt.t); (err == nil) != tt.ok {
+			t.Fatal(tt, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(root, "new")); err != nil || !info.Mode().IsRegular() {
+		t.Fatal(info, err)
+	}
+}
+
+func TestWriteVolumes(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := resource.MustParse("1Ki")
+	om := &meta.ObjectMeta{Namespace: "a", Name: "b"}
+	p := units.NewPodUnits(om, nil)
+	p.Volumes = []*units.Volume{
+		{Name: "scratch", SizeLimit: &limit},
+		{Name: "token", Projected: true, Secret: true, Files: []units.VolumeFile{{Path: "k", Data: []byte("v"), Mode: 0644}}},
+	}
+	if err := s.CreatePodUnits(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	path := s.(*FileStore).volumesPath("a", "b")
+	if info, err := os.Stat(filepath.Join(path, "token")); err != nil || info.Mode().Perm() != SecretDirMode {
+		t.Fatal(info, err)
+	}
+	if info, err := os.Stat(filepath.Dir(path)); err != nil || info.Mode().Perm() != 0700 {
+		t.Fatal(info, err)
+	}
+	if info, err := os.Stat(filepath.Join(path, "scratch")); err != nil || info.Mode().Perm() != EmptyDirMode {
+		t.Fatal(info, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, "scratch", "f"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if usage, err := s.VolumeUsage(ctx, "a", "b", "scratch"); err != nil || usage < 4096 {
+		t.Fatal(usage, err)
+	}
+
+	p = units.NewPodUnits(&meta.ObjectMeta{Namespace: "a", Name: "c"}, nil)
+	p.Volumes = []*units.Volume{{Name: "host", HostPath: filepath.Join(path, "missing"), HostPathType: core.HostPathDirectory}}
+	if err := s.CreatePodUnits(ctx, p); !errors.Is(err, errs.ErrBadHostPath) {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteProjected(t *testing.T) {
+	dir := t.TempDir()
+	read := func(path string) string {
+		data, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	v := &units.Volume{Name: "config", Projected: true, Files: []units.VolumeFile{
+		{Path: "a", Data: []byte("1"), Mode: 0644},
+		{Path: "app/b", Data: []byte("2"), Mode: 0600},
+	}}
+	if changed, err := writeProjected(dir, v); err != nil || !changed {
+		t.Fatal(changed, err)
+	}
+	old, err := os.Readlink(filepath.Join(dir, DataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read("a") != "1" || read("app/b") != "2" || read(filepath.Join(DataDir, "app/b")) != "2" {
+		t.Fatal(old)
+	}
+	if changed, err := writeProjected(dir, v); err != nil || changed {
+		t.Fatal(changed, err)
+	}
+
+	v.Files = []units.VolumeFile{{Path: "a", Data: []byte("3"), Mode: 0644}}
+	if changed, err := writeProjected(dir, v); err != nil || !changed {
+		t.Fatal(changed, err)
+	}
+	current, err := os.Readlink(filepath.Join(dir, DataDir))
+	if err != nil || current == old {
+		t.Fatal(current, err)
+	}
+	if read("a") != "3" {
+		t.Fatal(read("a"))
+	}
+	for _, path := range []string{old, "app", DataTmpDir} {
+		if _, err := os.Lstat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+			t.Fatal(path, err)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package unitlet
 
 import (
+	"os"
+
 	"github.com/virtual-kubelet/node-cli/provider"
 
+	"github.com/anqur/unitlet/internal/execs"
+	"github.com/anqur/unitlet/internal/journals"
 	"github.com/anqur/unitlet/internal/states"
 	"github.com/anqur/unitlet/internal/stores"
 	"github.com/anqur/unitlet/pkg/providers"
@@ -12,8 +16,10 @@ import (
 const ProviderName = units.Prefix
 
 var (
-	NewFileStore = stores.NewFileStore
-	NewDbusState = states.NewDbusState
+	NewFileStore  = stores.NewFileStore
+	NewDbusState  = states.NewDbusState
+	NewJournalctl = journals.NewJournalctl
+	NewNsenter    = execs.NewNsenter
 )
 
 func New(cfg provider.InitConfig) (provider.Provider, error) {
@@ -27,5 +33,19 @@ func New(cfg provider.InitConfig) (provider.Provider, error) {
 		return nil, err
 	}
 
-	return providers.NewUnitlet(&cfg, store, state), nil
+	journal, err := NewJournalctl()
+	if err != nil {
+		return nil, err
+	}
+
+	executor, err := NewNsenter()
+	if err != nil {
+		return nil, err
+	}
+
+	if units.HookBin, err = os.Executable(); err != nil {
+		return nil, err
+	}
+
+	return providers.NewUnitlet(&cfg, store, state, journal, executor), nil
 }
@@ -13,12 +13,41 @@ var (
 	ErrBadUnitFile = wrap("not a Pod-compatible unit file")
 	ErrBadUnitID   = wrap("invalid unit ID")
 
+	ErrEnvKeyNotFound = wrap("env key not found")
+	ErrBadPort        = wrap("invalid container port")
+	ErrRunAsRoot      = wrap("container must not run as root")
+	ErrBadMACOption   = wrap("invalid AppArmor or SELinux option")
+
 	ErrUnitFileExists  = wrap("unit file already exists")
 	ErrMarshalUnitFile = wrap("unit file marshal error")
 	ErrWriteUnitFile   = wrap("unit file write error")
 
+	ErrWriteInvocations = wrap("invocations write error")
+	ErrCreateStdin      = wrap("stdin create error")
+	ErrNoStdin          = wrap("container has no stdin")
+	ErrCreateTTY        = wrap("tty create error")
+	ErrNoTTY            = wrap("container has no tty")
+	ErrWriteCredentials = wrap("credentials write error")
+	ErrWriteVolume      = wrap("volume write error")
+	ErrUnmountVolume    = wrap("volume unmount error")
+	ErrBadHostPath      = wrap("invalid host path")
+
 	ErrSystemdNotRunning = wrap("systemd not running")
 	ErrDbusEnable        = wrap("dbus enable error")
+	ErrDbusStop          = wrap("dbus stop error")
+
+	ErrJournalNotFound = wrap("journalctl not found")
+	ErrReadJournal     = wrap("journal read error")
+
+	ErrNoPreviousInvocation = wrap("no previous invocation")
+
+	ErrNsenterNotFound     = wrap("nsenter not found")
+	ErrHookFailed          = wrap("lifecycle hook failed")
+	ErrBadProcess          = wrap("invalid process status")
+	ErrNoUnifiedCgroup     = wrap("unified cgroup not found")
+	ErrContainerNotRunning = wrap("container not running")
+
+	ErrProbeFailed = wrap("probe failed")
 )
 
 func wrap(msg string) error { return fmt.Errorf("%w: %s", Err, msg) }
@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+const DeadlineSyncPeriod = 5 * time.Second
+
+// syncDeadlines backs up RuntimeMaxSec= by stopping the target of pods past
+// activeDeadlineSeconds, since systemd counts from the last activation of a
+// unit and restarts would extend it. The start time comes from the API server.
+func (l *Unitlet) syncDeadlines(ctx context.Context) {
+	ticker := time.NewTicker(DeadlineSyncPeriod)
+	defer ticker.Stop()
+
+	expired := make(map[string]bool)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if l.cfg.ResourceManager == nil {
+			continue
+		}
+		l.stopExpiredPods(ctx, l.cfg.ResourceManager.GetPods(), expired, time.Now())
+	}
+}
+
+func (l *Unitlet) stopExpiredPods(ctx context.Context, pods []*core.Pod, expired map[string]bool, now time.Time) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	seen := make(map[string]bool, len(pods))
+	for _, pod := range pods {
+		key := podKey(pod.Namespace, pod.Name)
+		seen[key] = true
+		if expired[key] || !units.DeadlineExceeded(pod.Status.StartTime, pod.Spec.ActiveDeadlineSeconds, now) {
+			continue
+		}
+		expired[key] = true
+		target := units.TargetName(pod.Namespace, pod.Name)
+		log.G(ctx).Infof("%s exceeded its active deadline, stopping", target)
+		wg.Add(1)
+		go func(target units.Name) {
+			defer wg.Done()
+			l.mu.RLock()
+			defer l.mu.RUnlock()
+			if err := l.state.Stop(ctx, target); err != nil {
+				log.G(ctx).Warnf("stop %s: %v", target, err)
+			}
+		}(target)
+	}
+	for key := range expired {
+		if !seen[key] {
+			delete(expired, key)
+		}
+	}
+}
+
+// podStartTime prefers the start time already recorded by the API server over
+// the earliest start of the units, which moves on restarts.
+func (l *Unitlet) podStartTime(namespace, name string) *meta.Time {
+	if l.cfg.ResourceManager == nil {
+		return nil
+	}
+	for _, pod := range l.cfg.ResourceManager.GetPods() {
+		if pod.Namespace == namespace && pod.Name == name {
+			return pod.Status.StartTime
+		}
+	}
+	return nil
+}
+
+func (l *Unitlet) setRuntimeMax(pod *core.Pod, us []*units.Unit) {
+	startTime := pod.Status.StartTime
+	if startTime == nil {
+		startTime = l.podStartTime(pod.Namespace, pod.Name)
+	}
+	now := time.Now()
+	for _, u := range us {
+		u.RuntimeMax = units.RemainingDeadline(startTime, u.ActiveDeadline, now)
+	}
+}
@@ -0,0 +1,50 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+func (l *Unitlet) watchInvocations(ctx context.Context) {
+	ch, err := l.state.WatchInvocations(ctx)
+	if err != nil {
+		log.G(ctx).Warnf("watch invocations: %v", err)
+		return
+	}
+	l.recordInvocations(ctx)
+	for inv := range ch {
+		l.mu.Lock()
+		l.putInvocation(ctx, inv.Name, inv.ID)
+		l.mu.Unlock()
+	}
+}
+
+func (l *Unitlet) recordInvocations(ctx context.Context) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	views, err := l.state.Views(ctx)
+	if err != nil {
+		log.G(ctx).Warnf("record invocations: %v", err)
+		return
+	}
+	for _, pods := range views {
+		for _, view := range pods {
+			for i, name := range view.Names {
+				l.putInvocation(ctx, name, view.Invocations[i])
+			}
+		}
+	}
+}
+
+func (l *Unitlet) putInvocation(ctx context.Context, name units.Name, id string) {
+	if id == "" {
+		return
+	}
+	if err := l.store.PutInvocation(ctx, name, id); err != nil {
+		log.G(ctx).Warnf("put invocation %s of %s: %v", id, name, err)
+	}
+}
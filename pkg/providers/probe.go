@@ -0,0 +1,370 @@
+package providers
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+	core "k8s.io/api/core/v1"
+
+	"github.com/anqur/unitlet/pkg/errs"
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+const (
+	defaultPeriodSeconds    = 10
+	defaultTimeoutSeconds   = 1
+	defaultFailureThreshold = 3
+	defaultSuccessThreshold = 1
+)
+
+type probeKind string
+
+const (
+	probeStartup   probeKind = "startup"
+	probeLiveness  probeKind = "liveness"
+	probeReadiness probeKind = "readiness"
+)
+
+type probeResult struct {
+	hasStartup, hasReadiness bool
+
+	invocation     string
+	started, ready bool
+	changedAt      time.Time
+}
+
+type probedPod struct {
+	cancel context.CancelFunc
+	names  []units.Name
+}
+
+type prober struct {
+	l *Unitlet
+
+	mu      sync.Mutex
+	pods    map[string]*probedPod
+	results map[units.Name]*probeResult
+}
+
+func newProber(l *Unitlet) *prober {
+	return &prober{
+		l:       l,
+		pods:    make(map[string]*probedPod),
+		results: make(map[units.Name]*probeResult),
+	}
+}
+
+func podKey(namespace, name string) string { return namespace + "/" + name }
+
+func (p *prober) start(namespace, pod string, us []*units.Unit) {
+	p.stop(namespace, pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	probed := &probedPod{cancel: cancel}
+	p.pods[podKey(namespace, pod)] = probed
+	for _, u := range us {
+		if u.ID.IsInit() {
+			continue
+		}
+		name := u.ID.Name()
+		probed.names = append(probed.names, name)
+		p.results[name] = &probeResult{
+			hasStartup:   u.Probes.Startup != nil,
+			hasReadiness: u.Probes.Readiness != nil,
+		}
+		for kind, probe := range map[probeKind]*core.Probe{
+			probeStartup:   u.Probes.Startup,
+			probeLiveness:  u.Probes.Liveness,
+			probeReadiness: u.Probes.Readiness,
+		} {
+			if probe != nil {
+				go p.run(ctx, u, kind, probe)
+			}
+		}
+	}
+}
+
+func (p *prober) stop(namespace, pod string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := podKey(namespace, pod)
+	probed, ok := p.pods[key]
+	if !ok {
+		return
+	}
+	probed.cancel()
+	delete(p.pods, key)
+	for _, name := range probed.names {
+		delete(p.results, name)
+	}
+}
+
+// isReady reports a container without any known probe results as not ready,
+// readiness is only derived from a running container that has been registered
+// to the prober.
+func (p *prober) isReady(name units.Name, running bool) (bool, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.results[name]
+	if !ok {
+		return false, time.Time{}
+	}
+	return r.isReady(running), r.changedAt
+}
+
+func (r *probeResult) isReady(running bool) bool {
+	if !running || (r.hasStartup && !r.started) {
+		return false
+	}
+	if r.hasReadiness {
+		return r.ready
+	}
+	return true
+}
+
+func (p *prober) isStarted(name units.Name) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.results[name]
+	return !ok || !r.hasStartup || r.started
+}
+
+func (p *prober) update(name units.Name, invocation string, f func(r *probeResult)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.results[name]
+	if !ok {
+		return
+	}
+	r.update(invocation, f, time.Now())
+}
+
+func (r *probeResult) update(invocation string, f func(r *probeResult), now time.Time) {
+	started, ready := r.started, r.ready
+	if r.invocation != invocation {
+		r.invocation = invocation
+		r.started = false
+		r.ready = false
+	}
+	if f != nil {
+		f(r)
+	}
+	if r.started != started || r.ready != ready {
+		r.changedAt = now
+	}
+}
+
+type probeOutcome int
+
+const (
+	probePending probeOutcome = iota
+	probeSucceeded
+	probeFailed
+)
+
+type probeCounter struct {
+	successThreshold, failureThreshold int32
+
+	invocation          string
+	successes, failures int32
+}
+
+func newProbeCounter(probe *core.Probe) *probeCounter {
+	return &probeCounter{
+		successThreshold: orDefault(probe.SuccessThreshold, defaultSuccessThreshold),
+		failureThreshold: orDefault(probe.FailureThreshold, defaultFailureThreshold),
+	}
+}
+
+// reset starts counting from scratch for a new invocation of the unit.
+func (c *probeCounter) reset(invocation string) {
+	if c.invocation != invocation {
+		c.invocation = invocation
+		c.successes, c.failures = 0, 0
+	}
+}
+
+func (c *probeCounter) observe(ok bool) probeOutcome {
+	if ok {
+		c.successes, c.failures = c.successes+1, 0
+	} else {
+		c.successes, c.failures = 0, c.failures+1
+	}
+	switch {
+	case c.successes >= c.successThreshold:
+		return probeSucceeded
+	case c.failures >= c.failureThreshold:
+		return probeFailed
+	}
+	return probePending
+}
+
+func (p *prober) run(ctx context.Context, u *units.Unit, kind probeKind, probe *core.Probe) {
+	select {
+	case <-time.After(seconds(probe.InitialDelaySeconds, 0)):
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(seconds(probe.PeriodSeconds, defaultPeriodSeconds))
+	defer ticker.Stop()
+
+	name := u.ID.Name()
+	counter := newProbeCounter(probe)
+	for {
+		pid, current, err := p.process(ctx, name)
+		p.update(name, current, nil)
+		counter.reset(current)
+
+		if err == nil && p.shouldProbe(name, kind) {
+			err := p.probe(ctx, pid, u.PrivateNetwork, probe)
+			if err != nil {
+				log.G(ctx).Debugf("%s probe of %s failed: %v", kind, name, err)
+			}
+			switch counter.observe(err == nil) {
+			case probeSucceeded:
+				p.update(name, current, func(r *probeResult) {
+					switch kind {
+					case probeStartup:
+						r.started = true
+					case probeReadiness:
+						r.ready = true
+					}
+				})
+			case probeFailed:
+				if kind == probeReadiness {
+					p.update(name, current, func(r *probeResult) { r.ready = false })
+					break
+				}
+				log.G(ctx).Warnf("%s probe of %s failed %d times", kind, name, counter.failures)
+				if err := p.l.failUnit(ctx, u); err != nil {
+					log.G(ctx).Warnf("fail %s: %v", name, err)
+				}
+				counter.successes, counter.failures = 0, 0
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *prober) process(ctx context.Context, name units.Name) (int, string, error) {
+	p.l.mu.RLock()
+	defer p.l.mu.RUnlock()
+
+	return p.l.unitProcess(ctx, name)
+}
+
+func (p *prober) shouldProbe(name units.Name, kind probeKind) bool {
+	started := p.isStarted(name)
+	if kind == probeStartup {
+		return !started
+	}
+	return started
+}
+
+func (p *prober) probe(ctx context.Context, pid int, privateNetwork bool, probe *core.Probe) error {
+	ctx, cancel := context.WithTimeout(ctx, seconds(probe.TimeoutSeconds, defaultTimeoutSeconds))
+	defer cancel()
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if privateNetwork {
+			return p.l.executor.Dial(ctx, pid, network, addr)
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	switch h := probe.Handler; {
+	case h.Exec != nil:
+		return p.l.executor.Exec(ctx, pid, h.Exec.Command, discardIO{})
+
+	case h.HTTPGet != nil:
+		port := h.HTTPGet.Port.IntValue()
+		host := h.HTTPGet.Host
+		if host == "" {
+			host = units.LocalHost
+		}
+		scheme := string(h.HTTPGet.Scheme)
+		if scheme == "" {
+			scheme = "http"
+		}
+		u := &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port)), Path: h.HTTPGet.Path}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		for _, header := range h.HTTPGet.HTTPHeaders {
+			req.Header.Add(header.Name, header.Value)
+		}
+		client := &http.Client{Transport: &http.Transport{
+			DialContext:       dial,
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		}}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("%w: status=%d", errs.ErrProbeFailed, resp.StatusCode)
+		}
+		return nil
+
+	case h.TCPSocket != nil:
+		port := h.TCPSocket.Port.IntValue()
+		host := h.TCPSocket.Host
+		if host == "" {
+			host = units.LocalHost
+		}
+		conn, err := dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	return fmt.Errorf("%w: unknown probe handler", errs.ErrNotSupported)
+}
+
+func seconds(n, def int32) time.Duration {
+	return time.Duration(orDefault(n, def)) * time.Second
+}
+
+func orDefault(n, def int32) int32 {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
+
+type discardIO struct{}
+
+func (discardIO) Stdin() io.Reader            { return nil }
+func (discardIO) Stdout() io.WriteCloser      { return nil }
+func (discardIO) Stderr() io.WriteCloser      { return nil }
+func (discardIO) TTY() bool                   { return false }
+func (discardIO) Resize() <-chan api.TermSize { return nil }
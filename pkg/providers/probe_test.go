@@ -0,0 +1,113 @@
+package providers
+
+import (
+	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+)
+
+func TestProbeCounter(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		probe    core.Probe
+		observed []bool
+		outcomes []probeOutcome
+	}{
+		{
+			"defaults",
+			core.Probe{},
+			[]bool{true, false, false, false, true},
+			[]probeOutcome{probeSucceeded, probePending, probePending, probeFailed, probeSucceeded},
+		},
+		{
+			"success threshold",
+			core.Probe{SuccessThreshold: 2},
+			[]bool{true, false, true, true, true},
+			[]probeOutcome{probePending, probePending, probePending, probeSucceeded, probeSucceeded},
+		},
+		{
+			"failure threshold",
+			core.Probe{FailureThreshold: 1},
+			[]bool{false, true, false},
+			[]probeOutcome{probeFailed, probeSucceeded, probeFailed},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newProbeCounter(&tt.probe)
+			for i, ok := range tt.observed {
+				if outcome := c.observe(ok); outcome != tt.outcomes[i] {
+					t.Fatalf("#%d: got %d, want %d", i, outcome, tt.outcomes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProbeCounterReset(t *testing.T) {
+	c := newProbeCounter(&core.Probe{})
+	c.reset("a")
+	c.observe(false)
+	c.observe(false)
+	c.reset("a")
+	if c.failures != 2 {
+		t.Fatal(c.failures)
+	}
+	c.reset("b")
+	if c.observe(false) != probePending || c.failures != 1 {
+		t.Fatal(c.failures)
+	}
+}
+
+func TestProbeResultIsReady(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		result  probeResult
+		running bool
+		ready   bool
+	}{
+		{"no probes", probeResult{}, true, true},
+		{"not running", probeResult{}, false, false},
+		{"not started", probeResult{hasStartup: true}, true, false},
+		{"started", probeResult{hasStartup: true, started: true}, true, true},
+		{"started, not ready", probeResult{hasStartup: true, started: true, hasReadiness: true}, true, false},
+		{"not ready", probeResult{hasReadiness: true}, true, false},
+		{"ready", probeResult{hasReadiness: true, ready: true}, true, true},
+		{"ready, not running", probeResult{hasReadiness: true, ready: true}, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if ready := tt.result.isReady(tt.running); ready != tt.ready {
+				t.Fatal(ready)
+			}
+		})
+	}
+}
+
+func TestProbeResultUpdate(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	r := &probeResult{hasReadiness: true}
+
+	r.update("a", nil, at(1))
+	if !r.changedAt.IsZero() {
+		t.Fatal(r.changedAt)
+	}
+	r.update("a", func(r *probeResult) { r.ready = true }, at(2))
+	if !r.ready || !r.changedAt.Equal(at(2)) {
+		t.Fatal(r)
+	}
+	r.update("a", func(r *probeResult) { r.ready = true }, at(3))
+	if !r.changedAt.Equal(at(2)) {
+		t.Fatal(r.changedAt)
+	}
+	r.update("b", nil, at(4))
+	if r.ready || r.invocation != "b" || !r.changedAt.Equal(at(4)) {
+		t.Fatal(r)
+	}
+}
+
+func TestProberUnknownIsNotReady(t *testing.T) {
+	p := newProber(nil)
+	if ready, _ := p.isReady("unitlet.a.b.c.service", true); ready {
+		t.Fatal(ready)
+	}
+}
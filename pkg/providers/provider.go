@@ -2,71 +2,247 @@ package providers
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/virtual-kubelet/node-cli/provider"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/anqur/unitlet/pkg/errs"
 	"github.com/anqur/unitlet/pkg/units"
 )
 
 type Unitlet struct {
-	mu    sync.RWMutex
-	cfg   *provider.InitConfig
-	store units.Store
-	state units.State
+	mu       sync.RWMutex
+	cfg      *provider.InitConfig
+	store    units.Store
+	state    units.State
+	journal  units.Journal
+	executor units.Executor
+	prober   *prober
+	cancel   context.CancelFunc
 }
 
-func NewUnitlet(cfg *provider.InitConfig, store units.Store, state units.State) provider.Provider {
-	return &Unitlet{cfg: cfg, store: store, state: state}
+func NewUnitlet(
+	cfg *provider.InitConfig,
+	store units.Store,
+	state units.State,
+	journal units.Journal,
+	executor units.Executor,
+) provider.Provider {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Unitlet{cfg: cfg, store: store, state: state, journal: journal, executor: executor, cancel: cancel}
+	l.prober = newProber(l)
+	go l.syncVolumes(ctx)
+	go l.watchInvocations(ctx)
+	go l.syncDeadlines(ctx)
+	l.restoreUnits(ctx)
+	return l
+}
+
+// Close stops the background loops started by NewUnitlet.
+func (l *Unitlet) Close() error {
+	l.cancel()
+	return nil
+}
+
+// restoreUnits rebuilds the probes of existing pods. The terminal masters
+// died with the previous unitlet, so running TTY containers are failed and
+// get a new terminal if their restart policy brings them back.
+func (l *Unitlet) restoreUnits(ctx context.Context) {
+	views, err := l.state.Views(ctx)
+	if err != nil {
+		log.G(ctx).Warnf("restore units: %v", err)
+		return
+	}
+	for namespace, pods := range views {
+		for pod, view := range pods {
+			var us []*units.Unit
+			for _, name := range view.Names {
+				u, err := l.store.GetUnit(ctx, name)
+				if err != nil {
+					log.G(ctx).Warnf("restore %s: %v", name, err)
+					continue
+				}
+				us = append(us, u)
+				if u.TTY && isRunning(view.Status, u.ID.Container()) {
+					log.G(ctx).Warnf("%s lost its terminal on restart of unitlet, failing it", name)
+					if err := l.failUnit(ctx, u); err != nil {
+						log.G(ctx).Warnf("fail %s: %v", name, err)
+					}
+				}
+			}
+			l.prober.start(namespace, pod, us)
+		}
+	}
+}
+
+func isRunning(status *core.PodStatus, container string) bool {
+	for _, s := range status.ContainerStatuses {
+		if s.Name == container {
+			return s.State.Running != nil
+		}
+	}
+	return false
 }
 
 func (l *Unitlet) CreatePod(ctx context.Context, pod *core.Pod) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	us := units.FromPod(&pod.ObjectMeta, &pod.Spec)
+	us, err := units.FromPod(&pod.ObjectMeta, &pod.Spec, &nodeResources{l.cfg})
+	if err != nil {
+		return err
+	}
+	l.setRuntimeMax(pod, us)
+	p := units.NewPodUnits(&pod.ObjectMeta, us)
+	if p.Volumes, err = units.PodVolumes(&pod.ObjectMeta, &pod.Spec, &nodeResources{l.cfg}); err != nil {
+		return err
+	}
+	names := append([]units.Name{p.SliceName(), p.TargetName()}, p.Names...)
+	unload := func(names ...units.Name) {
+		l.forceUnload(ctx, names...)
+		_ = l.store.DeletePodVolumes(ctx, pod.Namespace, pod.Name)
+	}
+
 	if err := l.store.CreateUnits(ctx, us); err != nil {
 		return err
 	}
-	for _, u := range us {
-		name := u.ID.Name()
+	if err := l.store.CreatePodUnits(ctx, p); err != nil {
+		unload(p.Names...)
+		return err
+	}
+	for _, name := range names {
 		if err := l.state.Link(ctx, l.store.Location(name)); err != nil {
-			l.forceUnload(ctx, name)
+			unload(names...)
+			return err
+		}
+	}
+	if err := l.state.Enable(ctx, p.TargetName()); err != nil {
+		unload(names...)
+		return err
+	}
+	if err := l.state.Start(ctx, p.TargetName()); err != nil {
+		return err
+	}
+	l.prober.start(pod.Namespace, pod.Name, us)
+	return nil
+}
+
+func (l *Unitlet) UpdatePod(ctx context.Context, pod *core.Pod) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	us, err := units.FromPod(&pod.ObjectMeta, &pod.Spec, &nodeResources{l.cfg})
+	if err != nil {
+		return err
+	}
+	l.setRuntimeMax(pod, us)
+
+	var changed []*units.Unit
+	for _, u := range us {
+		old, err := l.store.GetUnit(ctx, u.ID.Name())
+		if err != nil {
 			return err
 		}
-		if err := l.state.Enable(ctx, name); err != nil {
-			l.forceUnload(ctx, name)
+		if !old.Equal(u) {
+			changed = append(changed, u)
+		}
+	}
+	p := units.NewPodUnits(&pod.ObjectMeta, us)
+	sliceChanged, err := l.store.UpdatePodSlice(ctx, p)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 && !sliceChanged {
+		return nil
+	}
+
+	if err := l.store.UpdateUnits(ctx, changed); err != nil {
+		return err
+	}
+	if err := l.state.Reload(ctx); err != nil {
+		return err
+	}
+	if sliceChanged {
+		if err := l.state.SetCgroup(ctx, p.SliceName(), &p.Cgroup); err != nil {
 			return err
 		}
-		if err := l.state.Start(ctx, name); err != nil {
+	}
+	for _, u := range changed {
+		if err := l.state.Restart(ctx, u.ID.Name()); err != nil {
 			return err
 		}
 	}
+	if len(changed) > 0 {
+		l.prober.start(pod.Namespace, pod.Name, us)
+	}
 	return nil
 }
 
-func (*Unitlet) UpdatePod(context.Context, *core.Pod) error { return nil }
-
 func (l *Unitlet) DeletePod(ctx context.Context, pod *core.Pod) error {
+	l.prober.stop(pod.Namespace, pod.Name)
+	l.stopPod(ctx, pod)
+
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	for _, u := range units.FromPod(&pod.ObjectMeta, &pod.Spec) {
-		name := u.ID.Name()
-		if err := l.state.Stop(ctx, name); err != nil {
-			continue
-		}
-		l.forceUnload(ctx, name)
-		_ = l.state.Reload(ctx)
+	target := units.TargetName(pod.Namespace, pod.Name)
+	names := units.PodNames(&pod.ObjectMeta, &pod.Spec)
+	l.forceUnload(ctx, target)
+	l.forceUnload(ctx, names...)
+	l.forceUnload(ctx, units.SliceName(pod.Namespace, pod.Name))
+	_ = l.state.Reload(ctx)
+	if err := l.store.DeletePodVolumes(ctx, pod.Namespace, pod.Name); err != nil {
+		log.G(ctx).Warnf("delete volumes of %s/%s: %v", pod.Namespace, pod.Name, err)
 	}
 	return nil
 }
 
+// StopTimeoutSlack gives systemd the time to escalate to SIGKILL on its own
+// before the stop job is given up.
+const StopTimeoutSlack = 5 * time.Second
+
+func (l *Unitlet) stopPod(ctx context.Context, pod *core.Pod) {
+	grace := int64(core.DefaultTerminationGracePeriodSeconds)
+	if s := pod.Spec.TerminationGracePeriodSeconds; s != nil {
+		grace = *s
+	}
+	override := false
+	if s := pod.DeletionGracePeriodSeconds; s != nil && *s != grace {
+		grace, override = *s, true
+	}
+	timeout := units.StopTimeout(grace)
+	stopCtx, cancel := context.WithTimeout(ctx, timeout+StopTimeoutSlack)
+	defer cancel()
+
+	names := units.PodNames(&pod.ObjectMeta, &pod.Spec)
+	if override {
+		for _, name := range names {
+			if err := l.state.SetStopTimeout(stopCtx, name, timeout); err != nil {
+				log.G(ctx).Warnf("set stop timeout of %s: %v", name, err)
+			}
+		}
+	}
+	target := units.TargetName(pod.Namespace, pod.Name)
+	if err := l.state.Stop(stopCtx, target); err != nil {
+		log.G(ctx).Warnf("stop %s: %v, killing", target, err)
+		for _, name := range names {
+			if err := l.state.Kill(context.Background(), name, syscall.SIGKILL); err != nil {
+				log.G(ctx).Warnf("kill %s: %v", name, err)
+			}
+		}
+	}
+}
+
 func (l *Unitlet) GetPod(ctx context.Context, namespace, name string) (*core.Pod, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -75,7 +251,8 @@ func (l *Unitlet) GetPod(ctx context.Context, namespace, name string) (*core.Pod
 	if err != nil {
 		return nil, err
 	}
-	cs, err := l.getContainers(ctx, view.Names)
+	l.refreshView(ctx, view)
+	initCs, cs, err := l.getContainers(ctx, view.Names)
 	if err != nil {
 		return nil, err
 	}
@@ -83,7 +260,7 @@ func (l *Unitlet) GetPod(ctx context.Context, namespace, name string) (*core.Pod
 	if err != nil {
 		return nil, err
 	}
-	return lead.ToPod(l.cfg.NodeName, cs, view.Status), nil
+	return lead.ToPod(l.cfg.NodeName, initCs, cs, view.Status), nil
 }
 
 func (l *Unitlet) GetPodStatus(ctx context.Context, namespace, name string) (*core.PodStatus, error) {
@@ -94,6 +271,7 @@ func (l *Unitlet) GetPodStatus(ctx context.Context, namespace, name string) (*co
 	if err != nil {
 		return nil, err
 	}
+	l.refreshView(ctx, view)
 	return view.Status, nil
 }
 
@@ -107,7 +285,8 @@ func (l *Unitlet) GetPods(ctx context.Context) (ret []*core.Pod, err error) {
 	}
 	for _, pods := range views {
 		for _, view := range pods {
-			cs, err := l.getContainers(ctx, view.Names)
+			l.refreshView(ctx, view)
+			initCs, cs, err := l.getContainers(ctx, view.Names)
 			if err != nil {
 				return nil, err
 			}
@@ -115,7 +294,7 @@ func (l *Unitlet) GetPods(ctx context.Context) (ret []*core.Pod, err error) {
 			if err != nil {
 				return nil, err
 			}
-			ret = append(ret, lead.ToPod(l.cfg.NodeName, cs, view.Status))
+			ret = append(ret, lead.ToPod(l.cfg.NodeName, initCs, cs, view.Status))
 		}
 	}
 	return
@@ -131,39 +310,256 @@ func (l *Unitlet) getView(ctx context.Context, namespace, name string) (*units.V
 		return nil, errdefs.NotFound(namespace)
 	}
 	view, ok := pods[name]
-	if ok {
+	if !ok {
 		return nil, errdefs.NotFound(name)
 	}
 	return view, nil
 }
 
-func (l *Unitlet) getContainers(ctx context.Context, names []units.Name) (ret []core.Container, err error) {
+func (l *Unitlet) getContainers(
+	ctx context.Context,
+	names []units.Name,
+) (initCs []core.Container, cs []core.Container, err error) {
 	for _, name := range names {
 		var u *units.Unit
 		if u, err = l.store.GetUnit(ctx, name); err != nil {
 			return
 		}
-		ret = append(ret, u.ToContainer())
+		if u.ID.IsInit() {
+			initCs = append(initCs, u.ToContainer())
+			continue
+		}
+		cs = append(cs, u.ToContainer())
 	}
 	return
 }
 
+func (l *Unitlet) containerName(ctx context.Context, namespace, podName, containerName string) units.Name {
+	id := units.NewID(namespace, podName, containerName)
+	if _, err := l.store.GetUnit(ctx, id.Name()); err == nil {
+		return id.Name()
+	}
+	initID := units.NewInitID(namespace, podName, containerName)
+	if _, err := l.store.GetUnit(ctx, initID.Name()); err == nil {
+		return initID.Name()
+	}
+	return id.Name()
+}
+
+func (l *Unitlet) refreshView(ctx context.Context, view *units.View) {
+	lead, err := l.store.GetUnit(ctx, view.Lead)
+	if err != nil {
+		return
+	}
+	view.Status.HostIP = l.cfg.InternalIP
+	if !lead.PrivateNetwork {
+		view.Status.PodIP = l.cfg.InternalIP
+		view.Status.PodIPs = []core.PodIP{{IP: l.cfg.InternalIP}}
+	}
+	if start := l.podStartTime(lead.ID.Namespace(), lead.ID.Pod()); start != nil {
+		view.Status.StartTime = start
+	}
+
+	var changedAt meta.Time
+	for i := range view.Status.ContainerStatuses {
+		s := &view.Status.ContainerStatuses[i]
+		id := units.NewID(lead.ID.Namespace(), lead.ID.Pod(), s.Name)
+		ready, at := l.prober.isReady(id.Name(), s.Ready)
+		s.Ready = ready
+		if at.After(changedAt.Time) {
+			changedAt = meta.NewTime(at)
+		}
+	}
+	if changedAt.IsZero() {
+		changedAt = meta.Now()
+	}
+	units.SetReadyConditions(view.Status, changedAt)
+	if units.DeadlineExceeded(view.Status.StartTime, lead.ActiveDeadline, time.Now()) {
+		units.SetDeadlineExceeded(view.Status)
+	}
+}
+
 func (l *Unitlet) GetContainerLogs(ctx context.Context, namespace, podName, containerName string, opts api.ContainerLogOpts) (io.ReadCloser, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	//TODO implement me
-	panic("implement me")
+	name := l.containerName(ctx, namespace, podName, containerName)
+	var invocation string
+	if opts.Previous {
+		var err error
+		if invocation, err = l.previousInvocation(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	return l.journal.Read(ctx, name, invocation, &opts)
+}
+
+func (l *Unitlet) previousInvocation(ctx context.Context, name units.Name) (string, error) {
+	props, err := l.state.Properties(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	current := props.InvocationID()
+	ids, err := l.store.GetInvocations(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		if ids[i] != current {
+			return ids[i], nil
+		}
+	}
+	return "", errdefs.AsNotFound(fmt.Errorf("%w: %s", errs.ErrNoPreviousInvocation, name))
+}
+
+func (l *Unitlet) RunInContainer(
+	ctx context.Context,
+	namespace, podName, containerName string,
+	cmd []string,
+	attach api.AttachIO,
+) error {
+	pid, err := l.mainPID(ctx, namespace, podName, containerName)
+	if err != nil {
+		return err
+	}
+	return l.executor.Exec(ctx, pid, cmd, attach)
+}
+
+func (l *Unitlet) AttachToContainer(
+	ctx context.Context,
+	namespace, podName, containerName string,
+	attach api.AttachIO,
+) error {
+	stdin, logs, err := l.openAttach(ctx, namespace, podName, containerName, attach)
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	var stdout io.Writer
+	if w := attach.Stdout(); w != nil {
+		stdout = w
+	}
+	if term, ok := stdin.(units.Terminal); ok {
+		defer term.Close()
+		go resizeTerminal(ctx, term, attach.Resize())
+		if stdout != nil {
+			// The terminal only carries the echo, the output is still read
+			// from the journal.
+			stdout = &syncWriter{w: stdout}
+			go func() { _, _ = io.Copy(stdout, term) }()
+		}
+	}
+	if in := attach.Stdin(); stdin != nil && in != nil {
+		go func() {
+			defer stdin.Close()
+			_, _ = io.Copy(stdin, in)
+		}()
+	}
+	if stdout != nil {
+		_, err = io.Copy(stdout, logs)
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (l *Unitlet) openAttach(
+	ctx context.Context,
+	namespace, podName, containerName string,
+	attach api.AttachIO,
+) (stdin io.WriteCloser, logs io.ReadCloser, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	name := l.containerName(ctx, namespace, podName, containerName)
+	if attach.TTY() {
+		if stdin, err = l.store.OpenTTY(ctx, name); err != nil {
+			return
+		}
+	} else if attach.Stdin() != nil {
+		if stdin, err = l.store.OpenStdin(ctx, name); err != nil {
+			return
+		}
+	}
+	logs, err = l.journal.Read(ctx, name, "", &api.ContainerLogOpts{Follow: true, SinceTime: time.Now()})
+	if err != nil && stdin != nil {
+		_ = stdin.Close()
+	}
+	return
 }
 
-func (l *Unitlet) RunInContainer(context.Context, string, string, string, []string, api.AttachIO) error {
-	return errs.ErrNotSupported
+func resizeTerminal(ctx context.Context, term units.Terminal, sizes <-chan api.TermSize) {
+	for {
+		select {
+		case size, ok := <-sizes:
+			if !ok {
+				return
+			}
+			_ = term.Resize(size)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (l *Unitlet) mainPID(ctx context.Context, namespace, podName, containerName string) (int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	pid, _, err := l.unitProcess(ctx, l.containerName(ctx, namespace, podName, containerName))
+	return pid, err
+}
+
+func (l *Unitlet) unitProcess(ctx context.Context, name units.Name) (pid int, invocation string, err error) {
+	props, err := l.state.Properties(ctx, name)
+	if err != nil {
+		return
+	}
+	invocation = props.InvocationID()
+	pid, err = strconv.Atoi(props.ContainerID().Host)
+	if err != nil || pid <= 0 {
+		return 0, invocation, fmt.Errorf("%w: %s", errs.ErrContainerNotRunning, name)
+	}
+	return
+}
+
+func (l *Unitlet) restartUnit(ctx context.Context, name units.Name) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state.Restart(ctx, name)
+}
+
+// failUnit handles a failed liveness or startup probe or a lost terminal, a
+// container that must not be restarted is killed and left terminated instead.
+func (l *Unitlet) failUnit(ctx context.Context, u *units.Unit) error {
+	if u.Restart != core.RestartPolicyNever {
+		return l.restartUnit(ctx, u.ID.Name())
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state.Kill(ctx, u.ID.Name(), syscall.SIGKILL)
 }
 
 func (l *Unitlet) ConfigureNode(context.Context, *core.Node) {}
 
-func (l *Unitlet) forceUnload(ctx context.Context, name units.Name) {
-	_ = l.state.Disable(ctx, name)
-	_ = l.state.ResetFailed(ctx, name)
-	_ = l.store.DeleteUnit(ctx, name)
+func (l *Unitlet) forceUnload(ctx context.Context, names ...units.Name) {
+	for _, name := range names {
+		_ = l.state.Disable(ctx, name)
+		_ = l.state.ResetFailed(ctx, name)
+		_ = l.store.DeleteUnit(ctx, name)
+	}
 }
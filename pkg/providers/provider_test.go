@@ -0,0 +1,229 @@
+package providers
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+type fakeState struct {
+	units.State
+	views units.Views
+}
+
+func (s *fakeState) Views(context.Context) (units.Views, error) { return s.views, nil }
+
+func TestGetView(t *testing.T) {
+	view := &units.View{Lead: "unitlet.a.b.c.service", Status: new(core.PodStatus)}
+	l := &Unitlet{state: &fakeState{views: units.Views{"a": {"b": view}}}}
+	ctx := context.Background()
+
+	if v, err := l.getView(ctx, "a", "b"); err != nil || v != view {
+		t.Fatal(v, err)
+	}
+	for _, tt := range []struct{ namespace, name string }{
+		{"a", "c"},
+		{"c", "b"},
+	} {
+		if _, err := l.getView(ctx, tt.namespace, tt.name); !errdefs.IsNotFound(err) {
+			t.Fatal(tt, err)
+		}
+		if _, err := l.GetPodStatus(ctx, tt.namespace, tt.name); !errdefs.IsNotFound(err) {
+			t.Fatal(tt, err)
+		}
+	}
+}
+
+type stopState struct {
+	units.State
+
+	mu       sync.Mutex
+	timeouts map[units.Name]time.Duration
+	slack    map[units.Name]time.Duration
+	stopped  []units.Name
+}
+
+func (s *stopState) SetStopTimeout(_ context.Context, name units.Name, timeout time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timeouts[name] = timeout
+	return nil
+}
+
+func (s *stopState) Stop(ctx context.Context, name units.Name) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = append(s.stopped, name)
+	if deadline, ok := ctx.Deadline(); ok {
+		s.slack[name] = time.Until(deadline)
+	}
+	return nil
+}
+
+func TestStopPodGrace(t *testing.T) {
+	spec := int64(30)
+	for _, tt := range []struct {
+		name     string
+		deletion *int64
+		timeout  time.Duration
+		override bool
+	}{
+		{"spec", nil, 30 * time.Second, false},
+		{"same", &spec, 30 * time.Second, false},
+		{"shorter", new(int64), units.MinStopTimeout * time.Second, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &stopState{timeouts: make(map[units.Name]time.Duration), slack: make(map[units.Name]time.Duration)}
+			l := &Unitlet{state: s}
+			pod := &core.Pod{
+				ObjectMeta: meta.ObjectMeta{Namespace: "a", Name: "b", DeletionGracePeriodSeconds: tt.deletion},
+				Spec: core.PodSpec{
+					TerminationGracePeriodSeconds: &spec,
+					Containers:                    []core.Container{{Name: "c"}},
+				},
+			}
+			l.stopPod(context.Background(), pod)
+
+			id := units.NewID("a", "b", "c")
+			target := units.TargetName("a", "b")
+			if timeout, ok := s.timeouts[id.Name()]; ok != tt.override || (ok && timeout != tt.timeout) {
+				t.Fatal(s.timeouts)
+			}
+			if len(s.stopped) != 1 || s.stopped[0] != target {
+				t.Fatal(s.stopped)
+			}
+			if slack := s.slack[target]; slack <= tt.timeout || slack > tt.timeout+StopTimeoutSlack {
+				t.Fatal(slack)
+			}
+		})
+	}
+}
+
+func TestStopExpiredPods(t *testing.T) {
+	s := &stopState{timeouts: make(map[units.Name]time.Duration), slack: make(map[units.Name]time.Duration)}
+	l := &Unitlet{state: s}
+	now := time.Now()
+	start := meta.NewTime(now.Add(-time.Minute))
+	short, long := int64(30), int64(120)
+	pods := []*core.Pod{
+		{ObjectMeta: meta.ObjectMeta{Namespace: "a", Name: "b"}, Spec: core.PodSpec{ActiveDeadlineSeconds: &short}, Status: core.PodStatus{StartTime: &start}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: "a", Name: "c"}, Spec: core.PodSpec{ActiveDeadlineSeconds: &long}, Status: core.PodStatus{StartTime: &start}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: "a", Name: "d"}, Spec: core.PodSpec{ActiveDeadlineSeconds: &short}},
+		{ObjectMeta: meta.ObjectMeta{Namespace: "a", Name: "e"}, Status: core.PodStatus{StartTime: &start}},
+	}
+
+	expired := map[string]bool{"x/y": true}
+	l.stopExpiredPods(context.Background(), pods, expired, now)
+	if len(expired) != 1 || !expired["a/b"] {
+		t.Fatal(expired)
+	}
+	if len(s.stopped) != 1 || s.stopped[0] != units.TargetName("a", "b") {
+		t.Fatal(s.stopped)
+	}
+	l.stopExpiredPods(context.Background(), pods, expired, now)
+	if len(s.stopped) != 1 {
+		t.Fatal(s.stopped)
+	}
+}
+
+type usageStore struct {
+	units.Store
+	usage int64
+}
+
+func (s *usageStore) VolumeUsage(context.Context, string, string, string) (int64, error) {
+	return s.usage, nil
+}
+
+func TestEnforceSizeLimits(t *testing.T) {
+	limit := resource.MustParse("1Mi")
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{Namespace: "a", Name: "b"},
+		Spec: core.PodSpec{Volumes: []core.Volume{
+			{Name: "memory", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{Medium: core.StorageMediumMemory, SizeLimit: &limit}}},
+			{Name: "disk", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{SizeLimit: &limit}}},
+		}},
+	}
+	for _, tt := range []struct {
+		usage   int64
+		stopped int
+	}{
+		{limit.Value(), 0},
+		{limit.Value() + 1, 1},
+	} {
+		s := &stopState{timeouts: make(map[units.Name]time.Duration), slack: make(map[units.Name]time.Duration)}
+		l := &Unitlet{state: s, store: &usageStore{usage: tt.usage}}
+		if err := l.enforceSizeLimits(context.Background(), pod); err != nil || len(s.stopped) != tt.stopped {
+			t.Fatal(tt, s.stopped, err)
+		}
+	}
+}
+
+type ttyState struct {
+	units.State
+	views     units.Views
+	restarted []units.Name
+	killed    []units.Name
+}
+
+func (s *ttyState) Views(context.Context) (units.Views, error) { return s.views, nil }
+
+func (s *ttyState) Restart(_ context.Context, name units.Name) error {
+	s.restarted = append(s.restarted, name)
+	return nil
+}
+
+func (s *ttyState) Kill(_ context.Context, name units.Name, _ syscall.Signal) error {
+	s.killed = append(s.killed, name)
+	return nil
+}
+
+type unitStore struct {
+	units.Store
+	units map[units.Name]*units.Unit
+}
+
+func (s *unitStore) GetUnit(_ context.Context, name units.Name) (*units.Unit, error) {
+	return s.units[name], nil
+}
+
+func TestRestoreUnitsTTY(t *testing.T) {
+	running := core.ContainerState{Running: new(core.ContainerStateRunning)}
+	done := core.ContainerState{Terminated: new(core.ContainerStateTerminated)}
+	us := []*units.Unit{
+		{ID: units.NewID("a", "b", "always"), TTY: true, Restart: core.RestartPolicyAlways},
+		{ID: units.NewID("a", "b", "never"), TTY: true, Restart: core.RestartPolicyNever},
+		{ID: units.NewID("a", "b", "done"), TTY: true, Restart: core.RestartPolicyAlways},
+		{ID: units.NewID("a", "b", "plain"), Restart: core.RestartPolicyAlways},
+	}
+	store := &unitStore{units: make(map[units.Name]*units.Unit)}
+	view := &units.View{Status: &core.PodStatus{ContainerStatuses: []core.ContainerStatus{
+		{Name: "always", State: running},
+		{Name: "never", State: running},
+		{Name: "done", State: done},
+		{Name: "plain", State: running},
+	}}}
+	for _, u := range us {
+		store.units[u.ID.Name()] = u
+		view.Names = append(view.Names, u.ID.Name())
+	}
+	s := &ttyState{views: units.Views{"a": {"b": view}}}
+	l := &Unitlet{state: s, store: store}
+	l.prober = newProber(l)
+	l.restoreUnits(context.Background())
+	l.prober.stop("a", "b")
+
+	if len(s.restarted) != 1 || s.restarted[0] != us[0].ID.Name() ||
+		len(s.killed) != 1 || s.killed[0] != us[1].ID.Name() {
+		t.Fatal(s.restarted, s.killed)
+	}
+}
@@ -0,0 +1,19 @@
+package providers
+
+import (
+	"github.com/virtual-kubelet/node-cli/provider"
+	core "k8s.io/api/core/v1"
+)
+
+type nodeResources struct{ cfg *provider.InitConfig }
+
+func (r *nodeResources) NodeName() string { return r.cfg.NodeName }
+func (r *nodeResources) HostIP() string   { return r.cfg.InternalIP }
+
+func (r *nodeResources) GetConfigMap(name, namespace string) (*core.ConfigMap, error) {
+	return r.cfg.ResourceManager.GetConfigMap(name, namespace)
+}
+
+func (r *nodeResources) GetSecret(name, namespace string) (*core.Secret, error) {
+	return r.cfg.ResourceManager.GetSecret(name, namespace)
+}
@@ -0,0 +1,108 @@
+package providers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/virtual-kubelet/virtual-kubelet/log"
+	core "k8s.io/api/core/v1"
+
+	"github.com/anqur/unitlet/pkg/units"
+)
+
+// VolumeSyncPeriod bounds how late configMap and secret volumes follow their
+// objects: an update reaches the files up to a minute later, like the
+// kubelet's own sync period. node-cli keeps its informers to itself, so there
+// are no events to react to, only the listers of the ResourceManager.
+const VolumeSyncPeriod = time.Minute
+
+func (l *Unitlet) syncVolumes(ctx context.Context) {
+	ticker := time.NewTicker(VolumeSyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if l.cfg.ResourceManager == nil {
+			continue
+		}
+		for _, pod := range l.cfg.ResourceManager.GetPods() {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := l.syncPodVolumes(ctx, pod); err != nil {
+				log.G(ctx).Warnf("sync volumes of %s/%s: %v", pod.Namespace, pod.Name, err)
+			}
+			if err := l.enforceSizeLimits(ctx, pod); err != nil {
+				log.G(ctx).Warnf("check volume sizes of %s/%s: %v", pod.Namespace, pod.Name, err)
+			}
+		}
+	}
+}
+
+func (l *Unitlet) syncPodVolumes(ctx context.Context, pod *core.Pod) error {
+	vs, err := units.PodVolumes(&pod.ObjectMeta, &pod.Spec, &nodeResources{l.cfg})
+	if err != nil {
+		return err
+	}
+	p := units.NewPodUnits(&pod.ObjectMeta, nil)
+	if p.Volumes = units.ProjectedVolumes(vs); len(p.Volumes) == 0 {
+		return nil
+	}
+	changed, err := l.store.UpdatePodVolumes(ctx, p)
+	if err != nil || len(changed) == 0 {
+		return err
+	}
+
+	if restart, _ := strconv.ParseBool(pod.Annotations[units.VolumeRestartAnnotation]); !restart {
+		return nil
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, name := range units.PodNames(&pod.ObjectMeta, &pod.Spec) {
+		u, err := l.store.GetUnit(ctx, name)
+		if err != nil {
+			return err
+		}
+		if u.ID.IsInit() {
+			continue
+		}
+		for _, v := range changed {
+			if u.MountsVolume(v) {
+				if err := l.state.Restart(ctx, name); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// enforceSizeLimits stops pods whose disk-backed emptyDir volumes outgrow
+// their sizeLimit, memory-backed ones are capped by the tmpfs size.
+func (l *Unitlet) enforceSizeLimits(ctx context.Context, pod *core.Pod) error {
+	for _, v := range pod.Spec.Volumes {
+		d := v.EmptyDir
+		if d == nil || d.Medium == core.StorageMediumMemory || d.SizeLimit == nil {
+			continue
+		}
+		usage, err := l.store.VolumeUsage(ctx, pod.Namespace, pod.Name, v.Name)
+		if err != nil {
+			return err
+		}
+		if usage <= d.SizeLimit.Value() {
+			continue
+		}
+		target := units.TargetName(pod.Namespace, pod.Name)
+		log.G(ctx).Warnf("%s: emptyDir %s uses %d bytes over its limit %s, stopping", target, v.Name, usage, d.SizeLimit)
+		return l.state.Stop(ctx, target)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package units
+
+import (
+	"context"
+	"net"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+)
+
+type Executor interface {
+	Exec(ctx context.Context, pid int, cmd []string, attach api.AttachIO) error
+	Dial(ctx context.Context, pid int, network, addr string) (net.Conn, error)
+}
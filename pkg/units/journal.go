@@ -0,0 +1,12 @@
+package units
+
+import (
+	"context"
+	"io"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
+)
+
+type Journal interface {
+	Read(ctx context.Context, name Name, invocation string, opts *api.ContainerLogOpts) (io.ReadCloser, error)
+}
@@ -0,0 +1,10 @@
+package units
+
+import core "k8s.io/api/core/v1"
+
+type Resources interface {
+	NodeName() string
+	HostIP() string
+	GetConfigMap(name, namespace string) (*core.ConfigMap, error)
+	GetSecret(name, namespace string) (*core.Secret, error)
+}
@@ -3,6 +3,8 @@ package units
 import (
 	"context"
 	"net/url"
+	"syscall"
+	"time"
 
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -17,26 +19,44 @@ type (
 
 		Start(ctx context.Context, name Name) error
 		Stop(ctx context.Context, name Name) error
+		Restart(ctx context.Context, name Name) error
+		Kill(ctx context.Context, name Name, signal syscall.Signal) error
+		SetCgroup(ctx context.Context, name Name, g *Cgroup) error
+		SetStopTimeout(ctx context.Context, name Name, timeout time.Duration) error
 
 		Reload(ctx context.Context) error
 		ResetFailed(ctx context.Context, name Name) error
 
 		Views(ctx context.Context) (Views, error)
 		Properties(ctx context.Context, name Name) (Properties, error)
+
+		WatchInvocations(ctx context.Context) (<-chan Invocation, error)
+	}
+
+	Invocation struct {
+		Name Name
+		ID   string
 	}
 
 	Views = map[string]map[string]*View
 	View  struct {
-		Lead   Name
-		Names  []Name
-		Status *core.PodStatus
+		Lead        Name
+		Names       []Name
+		Invocations []string
+		Status      *core.PodStatus
 	}
 
 	Properties interface {
 		ExitCode() int32
+		Signal() int32
 		RestartCount() int32
 		StartedAt() meta.Time
 		FinishedAt() meta.Time
 		ContainerID() *url.URL
+		InvocationID() string
+
+		Result() string
+		StateChangedAt() meta.Time
+		RestartDelay() time.Duration
 	}
 )
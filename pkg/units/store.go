@@ -2,6 +2,9 @@ package units
 
 import (
 	"context"
+	"io"
+
+	"github.com/virtual-kubelet/virtual-kubelet/node/api"
 )
 
 type (
@@ -13,5 +16,22 @@ type (
 		CreateUnits(ctx context.Context, us []*Unit) error
 		DeleteUnit(ctx context.Context, name Name) error
 		UpdateUnits(ctx context.Context, us []*Unit) error
+
+		CreatePodUnits(ctx context.Context, p *PodUnits) error
+		UpdatePodSlice(ctx context.Context, p *PodUnits) (changed bool, err error)
+		UpdatePodVolumes(ctx context.Context, p *PodUnits) (changed []string, err error)
+		DeletePodVolumes(ctx context.Context, namespace, pod string) error
+		VolumeUsage(ctx context.Context, namespace, pod, volume string) (int64, error)
+
+		GetInvocations(ctx context.Context, name Name) ([]string, error)
+		PutInvocation(ctx context.Context, name Name, id string) error
+
+		OpenStdin(ctx context.Context, name Name) (io.WriteCloser, error)
+		OpenTTY(ctx context.Context, name Name) (Terminal, error)
+	}
+
+	Terminal interface {
+		io.ReadWriteCloser
+		Resize(size api.TermSize) error
 	}
 )
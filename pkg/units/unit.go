@@ -1,6 +1,11 @@
 package units
 
-import "k8s.io/apimachinery/pkg/types"
+import (
+	"bytes"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
 
 type (
 	Name string
@@ -10,7 +15,71 @@ type (
 		Cmd    []string
 		PodUID types.UID
 
-		Workdir *string
-		User    *int64
+		Workdir  *string
+		Env      []core.EnvVar
+		Cgroup   Cgroup
+		Security Security
+		Restart  core.RestartPolicy
+
+		StopTimeout    *int64
+		ActiveDeadline *int64
+		RuntimeMax     *int64
+
+		Requires []Name
+
+		PostStart []string
+		PreStop   []string
+		Probes    Probes
+
+		HostNetwork      bool
+		HostIPC          bool
+		PrivateNetwork   bool
+		PrivateIPC       bool
+		PrivateTmp       bool
+		JoinsNamespaceOf Name
+
+		Stdin     bool
+		TTY       bool
+		StdinPath string
+		TTYPath   string
+
+		Credentials     []Credential
+		CredentialsPath string
+
+		Mounts      []Mount
+		VolumesPath string
+	}
+
+	Credential struct {
+		ID   string
+		Data []byte
 	}
 )
+
+func (u *Unit) Equal(other *Unit) bool {
+	a, b := *u, *other
+	a.StdinPath, b.StdinPath = "", ""
+	a.TTYPath, b.TTYPath = "", ""
+	a.CredentialsPath, b.CredentialsPath = "", ""
+	a.VolumesPath, b.VolumesPath = "", ""
+	a.RuntimeMax, b.RuntimeMax = nil, nil
+	x, err := a.Marshal()
+	if err != nil {
+		return false
+	}
+	y, err := b.Marshal()
+	if err != nil {
+		return false
+	}
+	if !bytes.Equal(x, y) {
+		return false
+	}
+	// The credential data is not in the unit file, the store loads it from the
+	// credential files.
+	for i := range u.Credentials {
+		if !bytes.Equal(u.Credentials[i].Data, other.Credentials[i].Data) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/anqur/unitlet/pkg/errs"
+)
+
+const (
+	CPUQuotaKey  = "CPUQuota"
+	CPUWeightKey = "CPUWeight"
+	MemoryMaxKey = "MemoryMax"
+	MemoryLowKey = "MemoryLow"
+	TasksMaxKey  = "TasksMax"
+
+	ResourceLimitKey   = "ResourceLimit"
+	ResourceRequestKey = "ResourceRequest"
+
+	ResourcePIDs core.ResourceName = "pids"
+
+	minShares    = 2
+	maxShares    = 262144
+	minCPUWeight = 1
+	maxCPUWeight = 10000
+)
+
+// Cgroup keeps the quantities of the container spec, the directives are lossy
+// and only derived from them.
+type Cgroup struct {
+	CPUQuota  *int64
+	CPUWeight *int64
+	MemoryMax *int64
+	MemoryLow *int64
+	TasksMax  *int64
+
+	Resources core.ResourceRequirements
+}
+
+var cgroupResources = []core.ResourceName{core.ResourceCPU, core.ResourceMemory, ResourcePIDs}
+
+func NewCgroup(r *core.ResourceRequirements) (ret Cgroup) {
+	ret.Resources = core.ResourceRequirements{
+		Limits:   pickResources(r.Limits),
+		Requests: pickResources(r.Requests),
+	}
+	if q, ok := r.Limits[core.ResourceCPU]; ok {
+		percent := (q.MilliValue() + 9) / 10
+		if percent < 1 {
+			percent = 1
+		}
+		ret.CPUQuota = &percent
+	}
+	if q, ok := r.Requests[core.ResourceCPU]; ok {
+		weight := sharesToWeight(q.MilliValue() * 1024 / 1000)
+		ret.CPUWeight = &weight
+	}
+	if q, ok := r.Limits[core.ResourceMemory]; ok {
+		n := q.Value()
+		ret.MemoryMax = &n
+	}
+	if q, ok := r.Requests[core.ResourceMemory]; ok {
+		n := q.Value()
+		ret.MemoryLow = &n
+	}
+	if q, ok := r.Limits[ResourcePIDs]; ok {
+		n := q.Value()
+		ret.TasksMax = &n
+	}
+	return
+}
+
+func pickResources(l core.ResourceList) core.ResourceList {
+	var ret core.ResourceList
+	for _, name := range cgroupResources {
+		if q, ok := l[name]; ok {
+			if ret == nil {
+				ret = make(core.ResourceList)
+			}
+			ret[name] = q.DeepCopy()
+		}
+	}
+	return ret
+}
+
+func (g *Cgroup) ToResourceRequirements() (ret core.ResourceRequirements) {
+	if len(g.Resources.Limits) > 0 || len(g.Resources.Requests) > 0 {
+		return *g.Resources.DeepCopy()
+	}
+	limits := make(core.ResourceList)
+	requests := make(core.ResourceList)
+	if n := g.CPUQuota; n != nil {
+		limits[core.ResourceCPU] = *resource.NewMilliQuantity(*n*10, resource.DecimalSI)
+	}
+	if n := g.CPUWeight; n != nil {
+		requests[core.ResourceCPU] = *resource.NewMilliQuantity(weightToShares(*n)*1000/1024, resource.DecimalSI)
+	}
+	if n := g.MemoryMax; n != nil {
+		limits[core.ResourceMemory] = *resource.NewQuantity(*n, resource.BinarySI)
+	}
+	if n := g.MemoryLow; n != nil {
+		requests[core.ResourceMemory] = *resource.NewQuantity(*n, resource.BinarySI)
+	}
+	if n := g.TasksMax; n != nil {
+		limits[ResourcePIDs] = *resource.NewQuantity(*n, resource.DecimalSI)
+	}
+	if len(limits) > 0 {
+		ret.Limits = limits
+	}
+	if len(requests) > 0 {
+		ret.Requests = requests
+	}
+	return
+}
+
+func (g *Cgroup) MarshalUnitEntries() (service, k8s []*unit.UnitEntry) {
+	if n := g.CPUQuota; n != nil {
+		service = append(service, &unit.UnitEntry{Name: CPUQuotaKey, Value: strconv.FormatInt(*n, 10) + "%"})
+	}
+	for _, e := range []struct {
+		key string
+		n   *int64
+	}{
+		{CPUWeightKey, g.CPUWeight},
+		{MemoryMaxKey, g.MemoryMax},
+		{MemoryLowKey, g.MemoryLow},
+		{TasksMaxKey, g.TasksMax},
+	} {
+		if e.n != nil {
+			service = append(service, &unit.UnitEntry{Name: e.key, Value: strconv.FormatInt(*e.n, 10)})
+		}
+	}
+	for _, e := range []struct {
+		key string
+		l   core.ResourceList
+	}{
+		{ResourceLimitKey, g.Resources.Limits},
+		{ResourceRequestKey, g.Resources.Requests},
+	} {
+		for _, name := range cgroupResources {
+			if q, ok := e.l[name]; ok {
+				k8s = append(k8s, &unit.UnitEntry{Name: e.key, Value: string(name) + "=" + q.String()})
+			}
+		}
+	}
+	return
+}
+
+func (g *Cgroup) UnmarshalUnitEntry(e *unit.UnitEntry) error {
+	var dst **int64
+	value := e.Value
+	switch e.Name {
+	case CPUQuotaKey:
+		dst = &g.CPUQuota
+		value = strings.TrimSuffix(value, "%")
+	case CPUWeightKey:
+		dst = &g.CPUWeight
+	case MemoryMaxKey:
+		dst = &g.MemoryMax
+	case MemoryLowKey:
+		dst = &g.MemoryLow
+	case TasksMaxKey:
+		dst = &g.TasksMax
+	case ResourceLimitKey:
+		return unmarshalResource(&g.Resources.Limits, e)
+	case ResourceRequestKey:
+		return unmarshalResource(&g.Resources.Requests, e)
+	default:
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %s=%s, err=%v", errs.ErrBadUnitFile, e.Name, e.Value, err)
+	}
+	*dst = &n
+	return nil
+}
+
+func unmarshalResource(l *core.ResourceList, e *unit.UnitEntry) error {
+	name, value, _ := strings.Cut(e.Value, "=")
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return fmt.Errorf("%w: %s=%s, err=%v", errs.ErrBadUnitFile, e.Name, e.Value, err)
+	}
+	if *l == nil {
+		*l = make(core.ResourceList)
+	}
+	(*l)[core.ResourceName(name)] = q
+	return nil
+}
+
+// restoreResources derives the directives again from the stored quantities,
+// so that they always match what NewCgroup would produce.
+func (g *Cgroup) restoreResources() {
+	if len(g.Resources.Limits) > 0 || len(g.Resources.Requests) > 0 {
+		*g = NewCgroup(&g.Resources)
+	}
+}
+
+func sharesToWeight(shares int64) int64 {
+	if shares < minShares {
+		shares = minShares
+	}
+	if shares > maxShares {
+		shares = maxShares
+	}
+	return minCPUWeight + ((shares-minShares)*(maxCPUWeight-minCPUWeight))/(maxShares-minShares)
+}
+
+func weightToShares(weight int64) int64 {
+	return minShares + ((weight-minCPUWeight)*(maxShares-minShares))/(maxCPUWeight-minCPUWeight)
+}
@@ -4,32 +4,136 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/coreos/go-systemd/v22/unit"
+	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/anqur/unitlet/pkg/errs"
 )
 
 const (
-	ServiceSection = "Service"
-	ExecStartKey   = "ExecStart"
-	WorkdirKey     = "WorkingDirectory"
-	UserKey        = "User"
+	ServiceSection   = "Service"
+	ExecStartKey     = "ExecStart"
+	ExecStartPostKey = "ExecStartPost"
+	ExecStopKey      = "ExecStop"
+	WorkdirKey       = "WorkingDirectory"
+	UserKey          = "User"
+	EnvKey           = "Environment"
+	StdinKey         = "StandardInput"
+	StdoutKey        = "StandardOutput"
+	TTYPathKey       = "TTYPath"
+	CredentialKey    = "LoadCredential"
+
+	TimeoutStopKey    = "TimeoutStopSec"
+	TimeoutStartKey   = "TimeoutStartSec"
+	RuntimeMaxKey     = "RuntimeMaxSec"
+	RestartPreventKey = "RestartPreventExitStatus"
+	MinStopTimeout    = 1
+
+	// RuntimeMaxSignal keeps systemd from restarting a unit it has stopped
+	// for running past RuntimeMaxSec=.
+	RuntimeMaxSignal = "SIGTERM"
+
+	RestartKey         = "Restart"
+	RestartSecKey      = "RestartSec"
+	RestartStepsKey    = "RestartSteps"
+	RestartMaxDelayKey = "RestartMaxDelaySec"
+
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNo        = "no"
+
+	RestartSec      = "10s"
+	RestartSteps    = "5"
+	RestartMaxDelay = "5min"
+
+	StdinFilePrefix = "file:"
+	StdinTTY        = "tty"
+	StdoutJournal   = "journal"
+
+	CredentialEnvPrefix = "env."
+	// CredentialEnvExec reads the files line by line instead of using command
+	// substitution, which would strip trailing newlines. The "\n" is unescaped
+	// by systemd.
+	CredentialEnvExec = `/bin/sh -c 'for f in "$$CREDENTIALS_DIRECTORY"/env.*; do ` +
+		`[ -e "$$f" ] || continue; v=; l=; while IFS= read -r l; do v="$$v$$l\n"; done < "$$f"; ` +
+		`export "$${f##*/env.}=$$v$$l"; done; exec "$$@"' ` + Prefix
+
+	// PreStopGuard skips ExecStop= when the main process has already exited,
+	// since a preStop hook only runs before a container is stopped.
+	PreStopGuard = `/bin/sh -c '[ "$$SERVICE_RESULT" = success ] && [ -z "$$EXIT_CODE" ] || exit 0; ` +
+		`exec "$$@"' ` + Prefix
 
 	K8sSection   = "X-Kubernetes"
 	NamespaceKey = "Namespace"
 	PodKey       = "Pod"
 	PodUIDKey    = "PodUID"
 	ContainerKey = "Container"
+	TTYKey       = "TTY"
+	InitKey      = "InitContainer"
+	HostNetKey   = "HostNetwork"
+	HostIPCKey   = "HostIPC"
+
+	ActiveDeadlineKey = "ActiveDeadlineSeconds"
+
+	UnitSection = "Unit"
+	RequiresKey = "Requires"
+	AfterKey    = "After"
+
+	TypeKey            = "Type"
+	TypeSimple         = "simple"
+	TypeOneshot        = "oneshot"
+	RemainAfterExitKey = "RemainAfterExit"
+
+	PrivateNetworkKey   = "PrivateNetwork"
+	PrivateIPCKey       = "PrivateIPC"
+	PrivateTmpKey       = "PrivateTmp"
+	JoinsNamespaceOfKey = "JoinsNamespaceOf"
 )
 
 func (u *Unit) MarshalUnitSections() []*unit.UnitSection {
-	serviceEntries := []*unit.UnitEntry{
-		{"Type", "simple"},
-		{ExecStartKey, strings.Join(u.Cmd, " ")},
+	cmd := strings.Join(u.Cmd, " ")
+	if u.hasEnvCredentials() {
+		cmd = CredentialEnvExec + " " + cmd
+	}
+	serviceEntries := []*unit.UnitEntry{{Name: TypeKey, Value: TypeSimple}}
+	switch {
+	case u.ID.IsInit():
+		serviceEntries = []*unit.UnitEntry{
+			{Name: TypeKey, Value: TypeOneshot},
+			{Name: RemainAfterExitKey, Value: "yes"},
+		}
+	case u.Restart == core.RestartPolicyNever, u.Restart == core.RestartPolicyOnFailure:
+		serviceEntries = append(serviceEntries, &unit.UnitEntry{Name: RemainAfterExitKey, Value: "yes"})
+	}
+	serviceEntries = append(
+		serviceEntries,
+		&unit.UnitEntry{Name: ExecStartKey, Value: cmd},
+		&unit.UnitEntry{Name: SliceKey, Value: string(SliceName(u.ID.Namespace(), u.ID.Pod()))},
+	)
+	for _, hook := range []struct {
+		key string
+		cmd []string
+	}{
+		{ExecStartPostKey, u.PostStart},
+		{ExecStopKey, u.PreStop},
+	} {
+		if len(hook.cmd) == 0 {
+			continue
+		}
+		value := quoteCommand(hook.cmd)
+		if u.hasEnvCredentials() {
+			value = CredentialEnvExec + " " + value
+		}
+		if hook.key == ExecStopKey {
+			value = PreStopGuard + " " + value
+		}
+		serviceEntries = append(serviceEntries, &unit.UnitEntry{Name: hook.key, Value: value})
 	}
 	if wd := u.Workdir; wd != nil {
 		serviceEntries = append(serviceEntries, &unit.UnitEntry{
@@ -37,39 +141,119 @@ func (u *Unit) MarshalUnitSections() []*unit.UnitSection {
 			Value: *wd,
 		})
 	}
-	if user := u.User; user != nil {
+	securityEntries, securityK8sEntries := u.Security.MarshalUnitEntries()
+	serviceEntries = append(serviceEntries, securityEntries...)
+	for _, e := range u.Env {
+		serviceEntries = append(serviceEntries, &unit.UnitEntry{
+			Name:  EnvKey,
+			Value: quoteWord(e.Name + "=" + e.Value),
+		})
+	}
+	for _, c := range u.Credentials {
 		serviceEntries = append(serviceEntries, &unit.UnitEntry{
-			Name:  UserKey,
-			Value: strconv.FormatInt(*user, 10),
+			Name:  CredentialKey,
+			Value: c.ID + ":" + filepath.Join(u.CredentialsPath, c.ID),
 		})
 	}
+	cgroupEntries, cgroupK8sEntries := u.Cgroup.MarshalUnitEntries()
+	serviceEntries = append(serviceEntries, cgroupEntries...)
+	serviceEntries = append(serviceEntries, u.marshalRestart()...)
+	if t := u.StopTimeout; t != nil {
+		serviceEntries = append(serviceEntries, &unit.UnitEntry{
+			Name:  TimeoutStopKey,
+			Value: strconv.FormatInt(int64(StopTimeout(*t)/time.Second), 10) + "s",
+		})
+	}
+	serviceEntries = append(serviceEntries, u.marshalRuntimeMax()...)
+	for _, e := range []struct {
+		key string
+		on  bool
+	}{
+		{PrivateNetworkKey, u.PrivateNetwork},
+		{PrivateIPCKey, u.PrivateIPC},
+		{PrivateTmpKey, u.PrivateTmp},
+	} {
+		if e.on {
+			serviceEntries = append(serviceEntries, &unit.UnitEntry{Name: e.key, Value: "yes"})
+		}
+	}
+	mountEntries, volumeMountEntries := u.marshalMounts()
+	serviceEntries = append(serviceEntries, mountEntries...)
+	if u.TTYPath != "" {
+		// Only stdin is bound to the terminal, so the output still reaches the
+		// journal and the container logs.
+		serviceEntries = append(
+			serviceEntries,
+			&unit.UnitEntry{Name: StdinKey, Value: StdinTTY},
+			&unit.UnitEntry{Name: TTYPathKey, Value: u.TTYPath},
+			&unit.UnitEntry{Name: StdoutKey, Value: StdoutJournal},
+		)
+	} else if u.StdinPath != "" {
+		serviceEntries = append(
+			serviceEntries,
+			&unit.UnitEntry{Name: StdinKey, Value: StdinFilePrefix + u.StdinPath},
+			&unit.UnitEntry{Name: StdoutKey, Value: StdoutJournal},
+		)
+	}
+
+	k8sEntries := []*unit.UnitEntry{
+		{Name: NamespaceKey, Value: u.ID.Namespace()},
+		{Name: PodKey, Value: u.ID.Pod()},
+		{Name: PodUIDKey, Value: string(u.PodUID)},
+		{Name: ContainerKey, Value: u.ID.Container()},
+	}
+	if u.TTY {
+		k8sEntries = append(k8sEntries, &unit.UnitEntry{Name: TTYKey, Value: strconv.FormatBool(u.TTY)})
+	}
+	for _, e := range []struct {
+		key string
+		on  bool
+	}{
+		{InitKey, u.ID.IsInit()},
+		{HostNetKey, u.HostNetwork},
+		{HostIPCKey, u.HostIPC},
+	} {
+		if e.on {
+			k8sEntries = append(k8sEntries, &unit.UnitEntry{Name: e.key, Value: strconv.FormatBool(true)})
+		}
+	}
+	if t := u.ActiveDeadline; t != nil {
+		k8sEntries = append(k8sEntries, &unit.UnitEntry{Name: ActiveDeadlineKey, Value: strconv.FormatInt(*t, 10)})
+	}
+	k8sEntries = append(k8sEntries, securityK8sEntries...)
+	k8sEntries = append(k8sEntries, cgroupK8sEntries...)
+	k8sEntries = append(k8sEntries, u.Probes.MarshalUnitEntries()...)
+	k8sEntries = append(k8sEntries, volumeMountEntries...)
+
+	unitEntries := []*unit.UnitEntry{
+		{Name: "Description", Value: u.ID.String()},
+		{Name: AfterKey, Value: "network-online.target"},
+		{Name: PartOfKey, Value: string(TargetName(u.ID.Namespace(), u.ID.Pod()))},
+		{Name: AfterKey, Value: string(TargetName(u.ID.Namespace(), u.ID.Pod()))},
+	}
+	if u.JoinsNamespaceOf != "" {
+		unitEntries = append(unitEntries, &unit.UnitEntry{Name: JoinsNamespaceOfKey, Value: string(u.JoinsNamespaceOf)})
+	}
+	for _, name := range u.Requires {
+		unitEntries = append(
+			unitEntries,
+			&unit.UnitEntry{Name: RequiresKey, Value: string(name)},
+			&unit.UnitEntry{Name: AfterKey, Value: string(name)},
+		)
+	}
 
 	return []*unit.UnitSection{
 		{
-			Section: "Unit",
-			Entries: []*unit.UnitEntry{
-				{"Description", u.ID.String()},
-				{"After", "network-online.target"},
-			},
+			Section: UnitSection,
+			Entries: unitEntries,
 		},
 		{
 			Section: ServiceSection,
 			Entries: serviceEntries,
 		},
-		{
-			Section: "Install",
-			Entries: []*unit.UnitEntry{
-				{"WantedBy", "multi-user.target"},
-			},
-		},
 		{
 			Section: K8sSection,
-			Entries: []*unit.UnitEntry{
-				{NamespaceKey, u.ID.Namespace()},
-				{PodKey, u.ID.Pod()},
-				{PodUIDKey, string(u.PodUID)},
-				{ContainerKey, u.ID.Container()},
-			},
+			Entries: k8sEntries,
 		},
 	}
 }
@@ -78,19 +262,82 @@ func (u *Unit) UnmarshalUnitSections(ss []*unit.UnitSection) error {
 	for _, s := range ss {
 		for _, e := range s.Entries {
 			switch s.Section {
+			case UnitSection:
+				switch e.Name {
+				case RequiresKey:
+					u.Requires = append(u.Requires, Name(e.Value))
+				case JoinsNamespaceOfKey:
+					u.JoinsNamespaceOf = Name(e.Value)
+				}
 			case ServiceSection:
 				switch e.Name {
 				case ExecStartKey:
-					u.Cmd = strings.Split(e.Value, " ")
+					u.Cmd = strings.Split(strings.TrimPrefix(e.Value, CredentialEnvExec+" "), " ")
+
+				case ExecStartPostKey, ExecStopKey:
+					value := strings.TrimPrefix(e.Value, PreStopGuard+" ")
+					cmd, err := unquoteCommand(strings.TrimPrefix(value, CredentialEnvExec+" "))
+					if err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+					if e.Name == ExecStartPostKey {
+						u.PostStart = cmd
+					} else {
+						u.PreStop = cmd
+					}
 
 				case WorkdirKey:
 					u.Workdir = &e.Value
-				case UserKey:
-					user, err := strconv.ParseInt(e.Value, 10, 64)
+				case UserKey, GroupKey,
+					NoNewPrivilegesKey, ProtectSystemKey, AppArmorProfileKey:
+					if err := u.Security.UnmarshalUnitEntry(e); err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+				case EnvKey:
+					kv, err := unquoteWord(e.Value)
+					if err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+					name, value, _ := strings.Cut(kv, "=")
+					u.Env = append(u.Env, core.EnvVar{Name: name, Value: value})
+				case CredentialKey:
+					id, path, ok := strings.Cut(e.Value, ":")
+					if !ok {
+						return fmt.Errorf("%w: u=%+v, credential=%s", errs.ErrBadUnitFile, u, e.Value)
+					}
+					u.Credentials = append(u.Credentials, Credential{ID: id})
+					u.CredentialsPath = filepath.Dir(path)
+				case CPUQuotaKey, CPUWeightKey, MemoryMaxKey, MemoryLowKey, TasksMaxKey:
+					if err := u.Cgroup.UnmarshalUnitEntry(e); err != nil {
+						return err
+					}
+				case PrivateNetworkKey:
+					u.PrivateNetwork = parseBoolean(e.Value)
+				case PrivateIPCKey:
+					u.PrivateIPC = parseBoolean(e.Value)
+				case PrivateTmpKey:
+					u.PrivateTmp = parseBoolean(e.Value)
+				case RestartKey:
+					restart, err := parseRestart(e.Value)
+					if err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+					u.Restart = restart
+				case TimeoutStopKey:
+					t, err := strconv.ParseInt(strings.TrimSuffix(e.Value, "s"), 10, 64)
 					if err != nil {
 						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
 					}
-					u.User = &user
+					u.StopTimeout = &t
+				case StdinKey:
+					if strings.HasPrefix(e.Value, StdinFilePrefix) {
+						u.Stdin = true
+						u.StdinPath = strings.TrimPrefix(e.Value, StdinFilePrefix)
+					} else if e.Value == StdinTTY {
+						u.Stdin = true
+					}
+				case TTYPathKey:
+					u.TTYPath = e.Value
 				}
 			case K8sSection:
 				switch e.Name {
@@ -102,13 +349,131 @@ func (u *Unit) UnmarshalUnitSections(ss []*unit.UnitSection) error {
 					u.PodUID = types.UID(e.Value)
 				case ContainerKey:
 					u.ID.c = e.Value
+				case TTYKey:
+					tty, err := strconv.ParseBool(e.Value)
+					if err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+					u.TTY = tty
+				case InitKey:
+					init, err := strconv.ParseBool(e.Value)
+					if err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+					u.ID.init = init
+				case ActiveDeadlineKey:
+					t, err := strconv.ParseInt(e.Value, 10, 64)
+					if err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+					u.ActiveDeadline = &t
+				case HostNetKey:
+					u.HostNetwork = parseBoolean(e.Value)
+				case HostIPCKey:
+					u.HostIPC = parseBoolean(e.Value)
+				case RunAsNonRootKey, FSGroupKey, PodSupplementalGroupsKey, SeccompProfileKey, SELinuxOptionsKey,
+					PrivilegedKey, AddCapabilitiesKey, DropCapabilitiesKey:
+					if err := u.Security.UnmarshalUnitEntry(e); err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+				case VolumeMountKey:
+					if err := u.unmarshalMount(e); err != nil {
+						return fmt.Errorf("%w: u=%+v, err=%v", errs.ErrBadUnitFile, u, err)
+					}
+				case StartupProbeKey, LivenessProbeKey, ReadinessProbeKey:
+					if err := u.Probes.UnmarshalUnitEntry(e); err != nil {
+						return err
+					}
+				case ResourceLimitKey, ResourceRequestKey:
+					if err := u.Cgroup.UnmarshalUnitEntry(e); err != nil {
+						return err
+					}
 				}
 			}
 		}
 	}
+	u.Cgroup.restoreResources()
 	return u.checkRequiredFields()
 }
 
+func (u *Unit) marshalRestart() []*unit.UnitEntry {
+	switch u.Restart {
+	case core.RestartPolicyNever:
+		return []*unit.UnitEntry{{Name: RestartKey, Value: RestartNo}}
+	case core.RestartPolicyOnFailure:
+		return append([]*unit.UnitEntry{{Name: RestartKey, Value: RestartOnFailure}}, restartBackoff()...)
+	default:
+		return append([]*unit.UnitEntry{{Name: RestartKey, Value: RestartAlways}}, restartBackoff()...)
+	}
+}
+
+// marshalRuntimeMax bounds the unit by what is left of activeDeadlineSeconds,
+// so the deadline holds while unitlet is down. Oneshot init units ignore
+// RuntimeMaxSec=, their whole run is the start job.
+func (u *Unit) marshalRuntimeMax() []*unit.UnitEntry {
+	t := u.RuntimeMax
+	if t == nil {
+		t = u.ActiveDeadline
+	}
+	if t == nil {
+		return nil
+	}
+	key := RuntimeMaxKey
+	if u.ID.IsInit() {
+		key = TimeoutStartKey
+	}
+	return []*unit.UnitEntry{
+		{Name: key, Value: strconv.FormatInt(*t, 10) + "s"},
+		{Name: RestartPreventKey, Value: RuntimeMaxSignal},
+	}
+}
+
+func restartBackoff() []*unit.UnitEntry {
+	return []*unit.UnitEntry{
+		{Name: RestartSecKey, Value: RestartSec},
+		{Name: RestartStepsKey, Value: RestartSteps},
+		{Name: RestartMaxDelayKey, Value: RestartMaxDelay},
+	}
+}
+
+func parseRestart(s string) (core.RestartPolicy, error) {
+	switch s {
+	case RestartAlways:
+		return core.RestartPolicyAlways, nil
+	case RestartOnFailure:
+		return core.RestartPolicyOnFailure, nil
+	case RestartNo:
+		return core.RestartPolicyNever, nil
+	}
+	return "", fmt.Errorf("unknown restart %q", s)
+}
+
+// StopTimeout clamps a grace period to at least MinStopTimeout, since a zero
+// TimeoutStopSec disables the timeout instead of killing immediately.
+func StopTimeout(seconds int64) time.Duration {
+	if seconds < MinStopTimeout {
+		seconds = MinStopTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func parseBoolean(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "yes", "y", "true", "t", "on":
+		return true
+	}
+	return false
+}
+
+func (u *Unit) hasEnvCredentials() bool {
+	for _, c := range u.Credentials {
+		if strings.HasPrefix(c.ID, CredentialEnvPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func (u *Unit) checkRequiredFields() error {
 	if u.ID.ns == "" ||
 		u.ID.p == "" ||
@@ -131,3 +496,55 @@ func (u *Unit) Unmarshal(data []byte) error {
 	}
 	return u.UnmarshalUnitSections(ss)
 }
+
+func quoteWord(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '%':
+			b.WriteString("%%")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func unquoteWord(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("unquoted word %q", s)
+	}
+	s = s[1 : len(s)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(s[i])
+			}
+		case c == '%' && i+1 < len(s) && s[i+1] == '%':
+			i++
+			b.WriteByte('%')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,171 @@
+package units
+
+import (
+	"fmt"
+	"sort"
+
+	core "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/errs"
+)
+
+const (
+	FieldPodName      = "metadata.name"
+	FieldPodNamespace = "metadata.namespace"
+	FieldPodUID       = "metadata.uid"
+	FieldNodeName     = "spec.nodeName"
+	FieldHostIP       = "status.hostIP"
+	FieldPodIP        = "status.podIP"
+)
+
+type envEntry struct {
+	name, value string
+	secret      bool
+}
+
+func containerEnv(
+	om *meta.ObjectMeta,
+	spec *core.PodSpec,
+	c *core.Container,
+	res Resources,
+) (env []core.EnvVar, creds []Credential, err error) {
+	var entries []envEntry
+	put := func(name, value string, secret bool) {
+		for i := range entries {
+			if entries[i].name == name {
+				entries[i] = envEntry{name, value, secret}
+				return
+			}
+		}
+		entries = append(entries, envEntry{name, value, secret})
+	}
+
+	for _, from := range c.EnvFrom {
+		var (
+			data   map[string]string
+			secret bool
+		)
+		switch {
+		case from.ConfigMapRef != nil:
+			ref := from.ConfigMapRef
+			cm, err := res.GetConfigMap(ref.Name, om.Namespace)
+			if err != nil {
+				if isOptional(ref.Optional, err) {
+					continue
+				}
+				return nil, nil, err
+			}
+			data = cm.Data
+		case from.SecretRef != nil:
+			ref := from.SecretRef
+			s, err := res.GetSecret(ref.Name, om.Namespace)
+			if err != nil {
+				if isOptional(ref.Optional, err) {
+					continue
+				}
+				return nil, nil, err
+			}
+			data = make(map[string]string, len(s.Data))
+			for k, v := range s.Data {
+				data[k] = string(v)
+			}
+			secret = true
+		}
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			put(from.Prefix+k, data[k], secret)
+		}
+	}
+
+	for _, e := range c.Env {
+		value, ok, err := envValue(om, spec, &e, res)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			put(e.Name, value, e.ValueFrom != nil && e.ValueFrom.SecretKeyRef != nil)
+		}
+	}
+
+	for _, e := range entries {
+		if e.secret {
+			creds = append(creds, Credential{ID: CredentialEnvPrefix + e.name, Data: []byte(e.value)})
+			continue
+		}
+		env = append(env, core.EnvVar{Name: e.name, Value: e.value})
+	}
+	return
+}
+
+func envValue(om *meta.ObjectMeta, spec *core.PodSpec, e *core.EnvVar, res Resources) (string, bool, error) {
+	from := e.ValueFrom
+	if from == nil {
+		return e.Value, true, nil
+	}
+
+	switch {
+	case from.ConfigMapKeyRef != nil:
+		ref := from.ConfigMapKeyRef
+		cm, err := res.GetConfigMap(ref.Name, om.Namespace)
+		if err != nil {
+			if isOptional(ref.Optional, err) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		value, ok := cm.Data[ref.Key]
+		if !ok && !isOptional(ref.Optional, nil) {
+			return "", false, fmt.Errorf("%w: configmap=%s, key=%s", errs.ErrEnvKeyNotFound, ref.Name, ref.Key)
+		}
+		return value, ok, nil
+
+	case from.SecretKeyRef != nil:
+		ref := from.SecretKeyRef
+		secret, err := res.GetSecret(ref.Name, om.Namespace)
+		if err != nil {
+			if isOptional(ref.Optional, err) {
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		value, ok := secret.Data[ref.Key]
+		if !ok && !isOptional(ref.Optional, nil) {
+			return "", false, fmt.Errorf("%w: secret=%s, key=%s", errs.ErrEnvKeyNotFound, ref.Name, ref.Key)
+		}
+		return string(value), ok, nil
+
+	case from.FieldRef != nil:
+		switch path := from.FieldRef.FieldPath; path {
+		case FieldPodName:
+			return om.Name, true, nil
+		case FieldPodNamespace:
+			return om.Namespace, true, nil
+		case FieldPodUID:
+			return string(om.UID), true, nil
+		case FieldNodeName:
+			if spec.NodeName != "" {
+				return spec.NodeName, true, nil
+			}
+			return res.NodeName(), true, nil
+		case FieldHostIP, FieldPodIP:
+			return res.HostIP(), true, nil
+		default:
+			return "", false, fmt.Errorf("%w: fieldPath=%s", errs.ErrNotSupported, path)
+		}
+	}
+
+	return "", false, fmt.Errorf("%w: env=%s", errs.ErrNotSupported, e.Name)
+}
+
+func isOptional(optional *bool, err error) bool {
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false
+	}
+	return optional != nil && *optional
+}
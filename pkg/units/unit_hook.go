@@ -0,0 +1,118 @@
+package units
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/anqur/unitlet/pkg/errs"
+)
+
+const (
+	LocalHost = "127.0.0.1"
+
+	DefaultHookBin  = "/usr/local/bin/unitlet"
+	HookHTTPCommand = "hook-http"
+	HookHeaderFlag  = "header"
+)
+
+// HookBin is the unitlet binary, which serves HTTP lifecycle hooks through
+// its hidden HookHTTPCommand.
+var HookBin = DefaultHookBin
+
+func hookCmd(c *core.Container, h *core.Handler) ([]string, error) {
+	switch {
+	case h == nil:
+		return nil, nil
+	case h.Exec != nil:
+		return h.Exec.Command, nil
+	case h.HTTPGet != nil:
+		return httpHookCmd(c, h.HTTPGet)
+	}
+	return nil, fmt.Errorf("%w: lifecycle handler of container %s", errs.ErrNotSupported, c.Name)
+}
+
+func httpHookCmd(c *core.Container, h *core.HTTPGetAction) ([]string, error) {
+	port, err := ContainerPort(c, h.Port)
+	if err != nil {
+		return nil, err
+	}
+	host := h.Host
+	if host == "" {
+		host = LocalHost
+	}
+	scheme := strings.ToLower(string(h.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+	u := &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port)), Path: h.Path}
+
+	cmd := []string{HookBin, HookHTTPCommand}
+	for _, header := range h.HTTPHeaders {
+		cmd = append(cmd, "-"+HookHeaderFlag, header.Name+": "+header.Value)
+	}
+	return append(cmd, u.String()), nil
+}
+
+func ContainerPort(c *core.Container, port intstr.IntOrString) (int, error) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), nil
+	}
+	for _, p := range c.Ports {
+		if p.Name == port.StrVal {
+			return int(p.ContainerPort), nil
+		}
+	}
+	n, err := strconv.Atoi(port.StrVal)
+	if err != nil {
+		return 0, fmt.Errorf("%w: port %q of container %s", errs.ErrBadPort, port.StrVal, c.Name)
+	}
+	return n, nil
+}
+
+func quoteCommand(cmd []string) string {
+	words := make([]string, len(cmd))
+	for i, w := range cmd {
+		words[i] = strings.ReplaceAll(quoteWord(w), "$", "$$")
+	}
+	return strings.Join(words, " ")
+}
+
+func unquoteCommand(s string) ([]string, error) {
+	var (
+		ret  []string
+		word strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			continue
+		case c != '"':
+			return nil, fmt.Errorf("unquoted word at %d in %q", i, s)
+		}
+		word.Reset()
+		word.WriteByte('"')
+		for i++; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				word.WriteByte(s[i])
+				i++
+			}
+			word.WriteByte(s[i])
+		}
+		if i == len(s) {
+			return nil, fmt.Errorf("unterminated word in %q", s)
+		}
+		word.WriteByte('"')
+		w, err := unquoteWord(strings.ReplaceAll(word.String(), "$$", "$"))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, w)
+	}
+	return ret, nil
+}
@@ -8,31 +8,51 @@ import (
 )
 
 const (
-	Prefix = "unitlet"
-	Suffix = ".service"
-	Sep    = "."
+	Prefix     = "unitlet"
+	InitPrefix = Prefix + "-init"
+	Suffix     = ".service"
+	Sep        = "."
 )
 
 type ID struct {
 	ns, p, c string
+	init     bool
 }
 
 func NewID(namespace, pod string, container string) ID {
-	return ID{namespace, pod, container}
+	return ID{ns: namespace, p: pod, c: container}
+}
+
+func NewInitID(namespace, pod string, container string) ID {
+	return ID{ns: namespace, p: pod, c: container, init: true}
 }
 
 func (i *ID) Namespace() string { return i.ns }
 func (i *ID) Pod() string       { return i.p }
 func (i *ID) Container() string { return i.c }
+func (i *ID) IsInit() bool      { return i.init }
+
+func (i *ID) String() string {
+	prefix := Prefix
+	if i.init {
+		prefix = InitPrefix
+	}
+	return strings.Join([]string{prefix, i.ns, i.p, i.c}, Sep)
+}
 
-func (i *ID) String() string { return strings.Join([]string{Prefix, i.ns, i.p, i.c}, Sep) }
-func (i *ID) Name() Name     { return Name(i.String() + Suffix) }
+func (i *ID) Name() Name { return Name(i.String() + Suffix) }
 
 func ParseName(name Name) (ID, error) {
 	const N = 5
 	ss := strings.SplitN(string(name), Sep, N)
-	if len(ss) != N || ss[0] != Prefix {
+	if len(ss) != N {
 		return ID{}, fmt.Errorf("%w: %s", errs.ErrBadUnitID, name)
 	}
-	return NewID(ss[1], ss[2], ss[3]), nil
+	switch ss[0] {
+	case Prefix:
+		return NewID(ss[1], ss[2], ss[3]), nil
+	case InitPrefix:
+		return NewInitID(ss[1], ss[2], ss[3]), nil
+	}
+	return ID{}, fmt.Errorf("%w: %s", errs.ErrBadUnitID, name)
 }
@@ -1,36 +1,148 @@
 package units
 
 import (
+	"time"
+
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func FromPod(om *meta.ObjectMeta, spec *core.PodSpec) (ret []*Unit) {
-	for _, c := range spec.Containers {
-		var (
-			wd   *string
-			user *int64
-		)
-		if c.WorkingDir != "" {
-			wd = &c.WorkingDir
+const (
+	ReasonPodInitializing  = "PodInitializing"
+	ReasonDeadlineExceeded = "DeadlineExceeded"
+	ReasonCrashLoopBackOff = "CrashLoopBackOff"
+	ReasonCompleted        = "Completed"
+	ReasonError            = "Error"
+	ReasonOOMKilled        = "OOMKilled"
+
+	IsolationAnnotation = Prefix + "/isolation"
+	IsolationPod        = "pod"
+)
+
+func FromPod(om *meta.ObjectMeta, spec *core.PodSpec, res Resources) (ret []*Unit, err error) {
+	restart := spec.RestartPolicy
+	if restart == "" {
+		restart = core.RestartPolicyAlways
+	}
+	initRestart := restart
+	if initRestart == core.RestartPolicyAlways {
+		initRestart = core.RestartPolicyOnFailure
+	}
+
+	var requires []Name
+	for i := range spec.InitContainers {
+		c := &spec.InitContainers[i]
+		u, err := fromContainer(om, spec, c, NewInitID(om.Namespace, om.Name, c.Name), res)
+		if err != nil {
+			return nil, err
 		}
-		if sc := spec.SecurityContext; sc != nil {
-			user = sc.RunAsUser
+		u.Restart = initRestart
+		u.Requires = requires
+		requires = []Name{u.ID.Name()}
+		ret = append(ret, u)
+	}
+
+	for i := range spec.Containers {
+		c := &spec.Containers[i]
+		u, err := fromContainer(om, spec, c, NewID(om.Namespace, om.Name, c.Name), res)
+		if err != nil {
+			return nil, err
 		}
+		u.Restart = restart
+		u.Requires = requires
+		ret = append(ret, u)
+	}
 
-		ret = append(ret, &Unit{
-			ID:     NewID(om.Namespace, om.Name, c.Name),
-			Cmd:    append(c.Command, c.Args...),
-			PodUID: om.UID,
+	if len(spec.Containers) > 0 && om.Annotations[IsolationAnnotation] == IsolationPod {
+		// The first app container owns the namespaces like it leads the view,
+		// init containers have exited by the time the apps start.
+		lead := ret[len(spec.InitContainers)].ID.Name()
+		for _, u := range ret {
+			u.PrivateNetwork = !spec.HostNetwork
+			u.PrivateIPC = !spec.HostIPC
+			u.PrivateTmp = true
+			if u.ID.Name() != lead {
+				u.JoinsNamespaceOf = lead
+			}
+		}
+	}
+	return
+}
 
-			Workdir: wd,
-			User:    user,
-		})
+func fromContainer(om *meta.ObjectMeta, spec *core.PodSpec, c *core.Container, id ID, res Resources) (*Unit, error) {
+	var wd *string
+	if c.WorkingDir != "" {
+		wd = &c.WorkingDir
+	}
+	stopTimeout := spec.TerminationGracePeriodSeconds
+	if stopTimeout == nil {
+		grace := int64(core.DefaultTerminationGracePeriodSeconds)
+		stopTimeout = &grace
+	}
+	security, err := NewSecurity(om, spec, c)
+	if err != nil {
+		return nil, err
+	}
+	env, creds, err := containerEnv(om, spec, c, res)
+	if err != nil {
+		return nil, err
+	}
+	probes, err := NewProbes(c)
+	if err != nil {
+		return nil, err
+	}
+	var postStart, preStop []string
+	if l := c.Lifecycle; l != nil {
+		if postStart, err = hookCmd(c, l.PostStart); err != nil {
+			return nil, err
+		}
+		if preStop, err = hookCmd(c, l.PreStop); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Unit{
+		ID:     id,
+		Cmd:    append(c.Command, c.Args...),
+		PodUID: om.UID,
+
+		Workdir:  wd,
+		Env:      env,
+		Cgroup:   NewCgroup(&c.Resources),
+		Security: security,
+
+		StopTimeout:    stopTimeout,
+		ActiveDeadline: spec.ActiveDeadlineSeconds,
+
+		PostStart: postStart,
+		PreStop:   preStop,
+		Probes:    probes,
+
+		HostNetwork: spec.HostNetwork,
+		HostIPC:     spec.HostIPC,
+
+		Stdin: c.Stdin,
+		TTY:   c.TTY,
+
+		Credentials: creds,
+
+		Mounts: containerMounts(spec, c),
+	}, nil
+}
+
+func PodNames(om *meta.ObjectMeta, spec *core.PodSpec) (ret []Name) {
+	for _, c := range spec.InitContainers {
+		id := NewInitID(om.Namespace, om.Name, c.Name)
+		ret = append(ret, id.Name())
+	}
+	for _, c := range spec.Containers {
+		id := NewID(om.Namespace, om.Name, c.Name)
+		ret = append(ret, id.Name())
 	}
 	return
 }
 
-func (u *Unit) ToPod(nodeName string, cs []core.Container, status *core.PodStatus) *core.Pod {
+func (u *Unit) ToPod(nodeName string, initCs, cs []core.Container, status *core.PodStatus) *core.Pod {
 	return &core.Pod{
 		TypeMeta: meta.TypeMeta{Kind: "Pod", APIVersion: "v1"},
 		ObjectMeta: meta.ObjectMeta{
@@ -38,7 +150,18 @@ func (u *Unit) ToPod(nodeName string, cs []core.Container, status *core.PodStatu
 			Namespace: u.ID.Namespace(),
 			UID:       u.PodUID,
 		},
-		Spec:   core.PodSpec{NodeName: nodeName, Containers: cs},
+		Spec: core.PodSpec{
+			NodeName:       nodeName,
+			InitContainers: initCs,
+			Containers:     cs,
+			RestartPolicy:  u.Restart,
+
+			TerminationGracePeriodSeconds: u.StopTimeout,
+			ActiveDeadlineSeconds:         u.ActiveDeadline,
+			HostNetwork:                   u.HostNetwork,
+			HostIPC:                       u.HostIPC,
+			SecurityContext:               u.Security.ToPodSecurityContext(),
+		},
 		Status: *status,
 	}
 }
@@ -50,18 +173,37 @@ func (u *Unit) ToContainer() (ret core.Container) {
 	if wd := u.Workdir; wd != nil {
 		ret.WorkingDir = *wd
 	}
-	if user := u.User; user != nil {
-		ret.SecurityContext = &core.SecurityContext{RunAsUser: user}
+	ret.SecurityContext = u.Security.ToSecurityContext()
+	ret.Env = u.Env
+	ret.VolumeMounts = u.toVolumeMounts()
+	ret.Resources = u.Cgroup.ToResourceRequirements()
+	if len(u.PostStart) > 0 || len(u.PreStop) > 0 {
+		ret.Lifecycle = &core.Lifecycle{
+			PostStart: toExecHandler(u.PostStart),
+			PreStop:   toExecHandler(u.PreStop),
+		}
 	}
+	ret.StartupProbe = u.Probes.Startup
+	ret.LivenessProbe = u.Probes.Liveness
+	ret.ReadinessProbe = u.Probes.Readiness
+	ret.Stdin = u.Stdin
+	ret.TTY = u.TTY
 	return
 }
 
+func toExecHandler(cmd []string) *core.Handler {
+	if len(cmd) == 0 {
+		return nil
+	}
+	return &core.Handler{Exec: &core.ExecAction{Command: cmd}}
+}
+
 func ToContainerStatus(name string, props Properties, state core.ContainerState) core.ContainerStatus {
 	return core.ContainerStatus{
 		Name:                 name,
 		State:                state,
 		LastTerminationState: state,
-		Ready:                true,
+		Ready:                state.Running != nil,
 		RestartCount:         props.RestartCount(),
 		ContainerID:          props.ContainerID().String(),
 	}
@@ -75,7 +217,10 @@ func ReduceContainerStatuses(statuses []core.ContainerStatus) core.PodPhase {
 	for _, s := range statuses {
 		st := s.State
 		if st.Waiting != nil {
-			return core.PodPending
+			if st.Waiting.Reason != ReasonCrashLoopBackOff {
+				return core.PodPending
+			}
+			running++
 		}
 
 		if st.Running != nil {
@@ -97,3 +242,125 @@ func ReduceContainerStatuses(statuses []core.ContainerStatus) core.PodPhase {
 
 	return core.PodFailed
 }
+
+func ReducePodStatus(status *core.PodStatus) core.PodPhase {
+	for _, s := range status.InitContainerStatuses {
+		if t := s.State.Terminated; t != nil {
+			if t.ExitCode != 0 {
+				return core.PodFailed
+			}
+			continue
+		}
+		for i := range status.ContainerStatuses {
+			st := &status.ContainerStatuses[i].State
+			if st.Running == nil && st.Terminated == nil {
+				st.Waiting = &core.ContainerStateWaiting{Reason: ReasonPodInitializing}
+			}
+		}
+		return core.PodPending
+	}
+	return ReduceContainerStatuses(status.ContainerStatuses)
+}
+
+// DeadlineExceeded tells whether a pod has been active longer than its
+// activeDeadlineSeconds, counting from the start time kept by the API server.
+func DeadlineExceeded(startTime *meta.Time, deadline *int64, now time.Time) bool {
+	if startTime == nil || deadline == nil {
+		return false
+	}
+	return !now.Before(startTime.Add(time.Duration(*deadline) * time.Second))
+}
+
+// RemainingDeadline is what is left of activeDeadlineSeconds at now, at least
+// a second so that systemd still enforces it. Pods not started yet get the
+// whole deadline.
+func RemainingDeadline(startTime *meta.Time, deadline *int64, now time.Time) *int64 {
+	if deadline == nil || startTime == nil {
+		return deadline
+	}
+	left := *deadline - int64(now.Sub(startTime.Time)/time.Second)
+	if left < 1 {
+		left = 1
+	}
+	return &left
+}
+
+func SetDeadlineExceeded(status *core.PodStatus) {
+	status.Phase = core.PodFailed
+	status.Reason = ReasonDeadlineExceeded
+	status.Message = "Pod was active on the node longer than the specified deadline"
+}
+
+func SetPodConditions(status *core.PodStatus, changedAt meta.Time) {
+	startTime := changedAt
+	if status.StartTime != nil {
+		startTime = *status.StartTime
+	}
+	SetCondition(status, core.PodScheduled, core.ConditionTrue, startTime)
+
+	initialized, initializedAt := core.ConditionTrue, startTime
+	for _, s := range status.InitContainerStatuses {
+		t := s.State.Terminated
+		if t == nil || t.ExitCode != 0 {
+			initialized, initializedAt = core.ConditionFalse, changedAt
+			break
+		}
+		if t.FinishedAt.After(initializedAt.Time) {
+			initializedAt = t.FinishedAt
+		}
+	}
+	SetCondition(status, core.PodInitialized, initialized, initializedAt)
+
+	readyAt := initializedAt
+	for _, s := range status.ContainerStatuses {
+		if r := s.State.Running; r != nil && r.StartedAt.After(readyAt.Time) {
+			readyAt = r.StartedAt
+		}
+	}
+	if initialized != core.ConditionTrue || !containersReady(status) {
+		readyAt = changedAt
+	}
+	SetReadyConditions(status, readyAt)
+}
+
+func SetReadyConditions(status *core.PodStatus, at meta.Time) {
+	ready := core.ConditionTrue
+	if !containersReady(status) {
+		ready = core.ConditionFalse
+	}
+	SetCondition(status, core.ContainersReady, ready, at)
+	for _, c := range status.Conditions {
+		if c.Type == core.PodInitialized && c.Status != core.ConditionTrue {
+			ready = core.ConditionFalse
+		}
+	}
+	SetCondition(status, core.PodReady, ready, at)
+}
+
+func containersReady(status *core.PodStatus) bool {
+	for _, s := range status.ContainerStatuses {
+		if !s.Ready || s.State.Waiting != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func SetCondition(status *core.PodStatus, typ core.PodConditionType, s core.ConditionStatus, at meta.Time) {
+	for i := range status.Conditions {
+		c := &status.Conditions[i]
+		if c.Type != typ {
+			continue
+		}
+		if c.Status != s {
+			c.Status = s
+			c.LastTransitionTime = at
+		}
+		return
+	}
+	status.Conditions = append(status.Conditions, core.PodCondition{
+		Type:               typ,
+		Status:             s,
+		LastTransitionTime: at,
+	})
+}
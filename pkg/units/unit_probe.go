@@ -0,0 +1,103 @@
+package units
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/anqur/unitlet/pkg/errs"
+)
+
+const (
+	StartupProbeKey   = "StartupProbe"
+	LivenessProbeKey  = "LivenessProbe"
+	ReadinessProbeKey = "ReadinessProbe"
+)
+
+type Probes struct {
+	Startup   *core.Probe
+	Liveness  *core.Probe
+	Readiness *core.Probe
+}
+
+// NewProbes resolves named ports up front, so the probes can be run again from
+// the unit file alone.
+func NewProbes(c *core.Container) (ret Probes, err error) {
+	if ret.Startup, err = containerProbe(c, c.StartupProbe); err != nil {
+		return
+	}
+	if ret.Liveness, err = containerProbe(c, c.LivenessProbe); err != nil {
+		return
+	}
+	ret.Readiness, err = containerProbe(c, c.ReadinessProbe)
+	return
+}
+
+func containerProbe(c *core.Container, p *core.Probe) (*core.Probe, error) {
+	if p == nil {
+		return nil, nil
+	}
+	p = p.DeepCopy()
+	for _, port := range []*intstr.IntOrString{httpGetPort(p.HTTPGet), tcpSocketPort(p.TCPSocket)} {
+		if port == nil {
+			continue
+		}
+		n, err := ContainerPort(c, *port)
+		if err != nil {
+			return nil, err
+		}
+		*port = intstr.FromInt(n)
+	}
+	return p, nil
+}
+
+func httpGetPort(h *core.HTTPGetAction) *intstr.IntOrString {
+	if h == nil {
+		return nil
+	}
+	return &h.Port
+}
+
+func tcpSocketPort(h *core.TCPSocketAction) *intstr.IntOrString {
+	if h == nil {
+		return nil
+	}
+	return &h.Port
+}
+
+func (p *Probes) MarshalUnitEntries() (ret []*unit.UnitEntry) {
+	for _, e := range []struct {
+		key   string
+		probe *core.Probe
+	}{
+		{StartupProbeKey, p.Startup},
+		{LivenessProbeKey, p.Liveness},
+		{ReadinessProbeKey, p.Readiness},
+	} {
+		if e.probe == nil {
+			continue
+		}
+		data, _ := json.Marshal(e.probe)
+		ret = append(ret, &unit.UnitEntry{Name: e.key, Value: string(data)})
+	}
+	return
+}
+
+func (p *Probes) UnmarshalUnitEntry(e *unit.UnitEntry) error {
+	probe := new(core.Probe)
+	if err := json.Unmarshal([]byte(e.Value), probe); err != nil {
+		return fmt.Errorf("%w: %s=%s, err=%v", errs.ErrBadUnitFile, e.Name, e.Value, err)
+	}
+	switch e.Name {
+	case StartupProbeKey:
+		p.Startup = probe
+	case LivenessProbeKey:
+		p.Liveness = probe
+	case ReadinessProbeKey:
+		p.Readiness = probe
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package units
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/anqur/unitlet/pkg/errs"
+)
+
+const (
+	GroupKey              = "Group"
+	SupplementalGroupsKey = "SupplementalGroups"
+
+	CapabilityBoundingSetKey = "CapabilityBoundingSet"
+	AmbientCapabilitiesKey   = "AmbientCapabilities"
+	NoNewPrivilegesKey       = "NoNewPrivileges"
+	ProtectSystemKey         = "ProtectSystem"
+	SystemCallFilterKey      = "SystemCallFilter"
+	SELinuxContextKey        = "SELinuxContext"
+	AppArmorProfileKey       = "AppArmorProfile"
+
+	ProtectSystemStrict     = "strict"
+	SystemCallFilterDefault = "@system-service"
+
+	CapPrefix = "CAP_"
+	CapAll    = "ALL"
+
+	SELinuxSep         = ":"
+	SELinuxDefaultUser = "system_u"
+	SELinuxDefaultRole = "system_r"
+	SELinuxDefaultType = "container_t"
+	// SELinuxDefaultLevel has no categories, as for spc_t containers.
+	SELinuxDefaultLevel = "s0"
+
+	RunAsNonRootKey          = "RunAsNonRoot"
+	FSGroupKey               = "FSGroup"
+	PodSupplementalGroupsKey = "PodSupplementalGroups"
+	SeccompProfileKey        = "SeccompProfile"
+	SELinuxOptionsKey        = "SELinuxOptions"
+	PrivilegedKey            = "Privileged"
+	AddCapabilitiesKey       = "AddCapabilities"
+	DropCapabilitiesKey      = "DropCapabilities"
+)
+
+var (
+	DefaultCapabilities = []string{
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+		"NET_BIND_SERVICE", "NET_RAW", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+	}
+
+	HardenedEntries = []*unit.UnitEntry{
+		{Name: "ProtectKernelTunables", Value: "yes"},
+		{Name: "ProtectKernelModules", Value: "yes"},
+		{Name: "ProtectKernelLogs", Value: "yes"},
+		{Name: "ProtectControlGroups", Value: "yes"},
+		{Name: "ProtectClock", Value: "yes"},
+		{Name: "ProtectHostname", Value: "yes"},
+		{Name: "LockPersonality", Value: "yes"},
+		{Name: "RestrictRealtime", Value: "yes"},
+	}
+
+	AppArmorProfilePattern = regexp.MustCompile(`^[A-Za-z0-9_./-]+$`)
+	SELinuxNamePattern     = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	SELinuxLevelPattern    = regexp.MustCompile(`^s[0-9]+(-s[0-9]+)?(:c[0-9]+([.,]c[0-9]+)*)?$`)
+)
+
+type Security struct {
+	User               *int64
+	Group              *int64
+	SupplementalGroups []int64
+	FSGroup            *int64
+	RunAsNonRoot       bool
+
+	Privileged             bool
+	AddCapabilities        []string
+	DropCapabilities       []string
+	NoNewPrivileges        bool
+	ReadOnlyRootFilesystem bool
+	SeccompRuntimeDefault  bool
+
+	SELinux         *core.SELinuxOptions
+	AppArmorProfile string
+}
+
+func NewSecurity(om *meta.ObjectMeta, spec *core.PodSpec, c *core.Container) (ret Security, err error) {
+	var (
+		nonRoot *bool
+		seccomp *core.SeccompProfile
+	)
+	if sc := spec.SecurityContext; sc != nil {
+		ret.User = sc.RunAsUser
+		ret.Group = sc.RunAsGroup
+		ret.SupplementalGroups = sc.SupplementalGroups
+		ret.FSGroup = sc.FSGroup
+		nonRoot = sc.RunAsNonRoot
+		seccomp = sc.SeccompProfile
+		ret.SELinux = sc.SELinuxOptions
+	}
+	if sc := c.SecurityContext; sc != nil {
+		if sc.RunAsUser != nil {
+			ret.User = sc.RunAsUser
+		}
+		if sc.RunAsGroup != nil {
+			ret.Group = sc.RunAsGroup
+		}
+		if sc.RunAsNonRoot != nil {
+			nonRoot = sc.RunAsNonRoot
+		}
+		if sc.SeccompProfile != nil {
+			seccomp = sc.SeccompProfile
+		}
+		if sc.SELinuxOptions != nil {
+			ret.SELinux = sc.SELinuxOptions
+		}
+		ret.Privileged = sc.Privileged != nil && *sc.Privileged
+		if caps := sc.Capabilities; caps != nil {
+			ret.AddCapabilities = capabilityNames(caps.Add)
+			ret.DropCapabilities = capabilityNames(caps.Drop)
+		}
+		ret.NoNewPrivileges = sc.AllowPrivilegeEscalation != nil && !*sc.AllowPrivilegeEscalation
+		ret.ReadOnlyRootFilesystem = sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem
+	}
+	ret.RunAsNonRoot = nonRoot != nil && *nonRoot
+	ret.SeccompRuntimeDefault = seccomp != nil && seccomp.Type == core.SeccompProfileTypeRuntimeDefault
+	profile := om.Annotations[core.AppArmorBetaContainerAnnotationKeyPrefix+c.Name]
+	if strings.HasPrefix(profile, core.AppArmorBetaProfileNamePrefix) {
+		ret.AppArmorProfile = strings.TrimPrefix(profile, core.AppArmorBetaProfileNamePrefix)
+	}
+	if ret.RunAsNonRoot && (ret.User == nil || *ret.User == 0) {
+		return ret, fmt.Errorf("%w: container %s", errs.ErrRunAsRoot, c.Name)
+	}
+	if err := ret.checkMACOptions(); err != nil {
+		return ret, fmt.Errorf("%w: container %s: %v", errs.ErrBadMACOption, c.Name, err)
+	}
+	return
+}
+
+func (s *Security) checkMACOptions() error {
+	if p := s.AppArmorProfile; p != "" && !AppArmorProfilePattern.MatchString(p) {
+		return fmt.Errorf("AppArmor profile %q", p)
+	}
+	o := s.SELinux
+	if o == nil {
+		return nil
+	}
+	for _, name := range []string{o.User, o.Role, o.Type} {
+		if name != "" && !SELinuxNamePattern.MatchString(name) {
+			return fmt.Errorf("SELinux name %q", name)
+		}
+	}
+	if l := o.Level; l != "" && !SELinuxLevelPattern.MatchString(l) {
+		return fmt.Errorf("SELinux level %q", l)
+	}
+	return nil
+}
+
+func (s *Security) MarshalUnitEntries() (service, k8s []*unit.UnitEntry) {
+	if user := s.User; user != nil {
+		service = append(service, &unit.UnitEntry{Name: UserKey, Value: strconv.FormatInt(*user, 10)})
+	}
+	if group := s.Group; group != nil {
+		service = append(service, &unit.UnitEntry{Name: GroupKey, Value: strconv.FormatInt(*group, 10)})
+	}
+	groups := s.SupplementalGroups
+	if g := s.FSGroup; g != nil && !hasGroup(groups, *g) {
+		groups = append(groups[:len(groups):len(groups)], *g)
+	}
+	if len(groups) > 0 {
+		service = append(service, &unit.UnitEntry{Name: SupplementalGroupsKey, Value: formatGroups(groups)})
+	}
+
+	if !s.Privileged {
+		if bounding, ok := s.boundingSet(); ok {
+			service = append(service, &unit.UnitEntry{Name: CapabilityBoundingSetKey, Value: bounding})
+		}
+		service = append(service, HardenedEntries...)
+	}
+	if len(s.AddCapabilities) > 0 && !hasCapability(s.AddCapabilities, CapAll) {
+		service = append(service, &unit.UnitEntry{
+			Name:  AmbientCapabilitiesKey,
+			Value: strings.Join(withCapPrefix(s.AddCapabilities), " "),
+		})
+	}
+	if s.NoNewPrivileges {
+		service = append(service, &unit.UnitEntry{Name: NoNewPrivilegesKey, Value: "yes"})
+	}
+	if s.ReadOnlyRootFilesystem {
+		service = append(service, &unit.UnitEntry{Name: ProtectSystemKey, Value: ProtectSystemStrict})
+	}
+	if s.SeccompRuntimeDefault && !s.Privileged {
+		service = append(service, &unit.UnitEntry{Name: SystemCallFilterKey, Value: SystemCallFilterDefault})
+	}
+	if o := s.SELinux; o != nil {
+		service = append(service, &unit.UnitEntry{Name: SELinuxContextKey, Value: selinuxContext(o)})
+	}
+	if p := s.AppArmorProfile; p != "" {
+		service = append(service, &unit.UnitEntry{Name: AppArmorProfileKey, Value: p})
+	}
+
+	if s.RunAsNonRoot {
+		k8s = append(k8s, &unit.UnitEntry{Name: RunAsNonRootKey, Value: strconv.FormatBool(true)})
+	}
+	if s.Privileged {
+		k8s = append(k8s, &unit.UnitEntry{Name: PrivilegedKey, Value: strconv.FormatBool(true)})
+	}
+	if len(s.AddCapabilities) > 0 {
+		k8s = append(k8s, &unit.UnitEntry{Name: AddCapabilitiesKey, Value: strings.Join(s.AddCapabilities, " ")})
+	}
+	if len(s.DropCapabilities) > 0 {
+		k8s = append(k8s, &unit.UnitEntry{Name: DropCapabilitiesKey, Value: strings.Join(s.DropCapabilities, " ")})
+	}
+	if g := s.FSGroup; g != nil {
+		k8s = append(k8s, &unit.UnitEntry{Name: FSGroupKey, Value: strconv.FormatInt(*g, 10)})
+	}
+	if s.SeccompRuntimeDefault {
+		k8s = append(k8s, &unit.UnitEntry{Name: SeccompProfileKey, Value: string(core.SeccompProfileTypeRuntimeDefault)})
+	}
+	if len(s.SupplementalGroups) > 0 {
+		k8s = append(k8s, &unit.UnitEntry{Name: PodSupplementalGroupsKey, Value: formatGroups(s.SupplementalGroups)})
+	}
+	if o := s.SELinux; o != nil {
+		k8s = append(k8s, &unit.UnitEntry{
+			Name:  SELinuxOptionsKey,
+			Value: strings.Join([]string{o.User, o.Role, o.Type, o.Level}, SELinuxSep),
+		})
+	}
+	return
+}
+
+func (s *Security) UnmarshalUnitEntry(e *unit.UnitEntry) error {
+	switch e.Name {
+	case UserKey, GroupKey, FSGroupKey:
+		id, err := strconv.ParseInt(e.Value, 10, 64)
+		if err != nil {
+			return err
+		}
+		switch e.Name {
+		case UserKey:
+			s.User = &id
+		case GroupKey:
+			s.Group = &id
+		case FSGroupKey:
+			s.FSGroup = &id
+		}
+	case PodSupplementalGroupsKey:
+		for _, f := range strings.Fields(e.Value) {
+			g, err := strconv.ParseInt(f, 10, 64)
+			if err != nil {
+				return err
+			}
+			s.SupplementalGroups = append(s.SupplementalGroups, g)
+		}
+	case RunAsNonRootKey, PrivilegedKey:
+		on, err := strconv.ParseBool(e.Value)
+		if err != nil {
+			return err
+		}
+		if e.Name == RunAsNonRootKey {
+			s.RunAsNonRoot = on
+		} else {
+			s.Privileged = on
+		}
+	case AddCapabilitiesKey:
+		s.AddCapabilities = strings.Fields(e.Value)
+	case DropCapabilitiesKey:
+		s.DropCapabilities = strings.Fields(e.Value)
+	case NoNewPrivilegesKey:
+		s.NoNewPrivileges = parseBoolean(e.Value)
+	case ProtectSystemKey:
+		s.ReadOnlyRootFilesystem = e.Value == ProtectSystemStrict
+	case SeccompProfileKey:
+		s.SeccompRuntimeDefault = e.Value == string(core.SeccompProfileTypeRuntimeDefault)
+	case SELinuxOptionsKey:
+		fields := strings.SplitN(e.Value, SELinuxSep, 4)
+		if len(fields) != 4 {
+			return fmt.Errorf("bad SELinux options %q", e.Value)
+		}
+		s.SELinux = &core.SELinuxOptions{User: fields[0], Role: fields[1], Type: fields[2], Level: fields[3]}
+	case AppArmorProfileKey:
+		s.AppArmorProfile = e.Value
+	}
+	return nil
+}
+
+func selinuxContext(o *core.SELinuxOptions) string {
+	or := func(s, def string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	}
+	return strings.Join([]string{
+		or(o.User, SELinuxDefaultUser),
+		or(o.Role, SELinuxDefaultRole),
+		or(o.Type, SELinuxDefaultType),
+		or(o.Level, SELinuxDefaultLevel),
+	}, SELinuxSep)
+}
+
+func (s *Security) boundingSet() (string, bool) {
+	if hasCapability(s.AddCapabilities, CapAll) {
+		return "", false
+	}
+	var caps []string
+	if !hasCapability(s.DropCapabilities, CapAll) {
+		for _, c := range DefaultCapabilities {
+			if !hasCapability(s.DropCapabilities, c) {
+				caps = append(caps, c)
+			}
+		}
+	}
+	for _, c := range s.AddCapabilities {
+		if !hasCapability(caps, c) {
+			caps = append(caps, c)
+		}
+	}
+	sort.Strings(caps)
+	return strings.Join(withCapPrefix(caps), " "), true
+}
+
+func capabilityNames(caps []core.Capability) (ret []string) {
+	for _, c := range caps {
+		ret = append(ret, strings.TrimPrefix(strings.ToUpper(string(c)), CapPrefix))
+	}
+	return
+}
+
+func withCapPrefix(caps []string) []string {
+	ret := make([]string, len(caps))
+	for i, c := range caps {
+		ret[i] = CapPrefix + c
+	}
+	return ret
+}
+
+func hasCapability(caps []string, c string) bool {
+	for _, x := range caps {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGroup(groups []int64, g int64) bool {
+	for _, x := range groups {
+		if x == g {
+			return true
+		}
+	}
+	return false
+}
+
+func formatGroups(groups []int64) string {
+	gids := make([]string, len(groups))
+	for i, g := range groups {
+		gids[i] = strconv.FormatInt(g, 10)
+	}
+	return strings.Join(gids, " ")
+}
+
+func (s *Security) ToSecurityContext() *core.SecurityContext {
+	ret := &core.SecurityContext{RunAsUser: s.User, RunAsGroup: s.Group}
+	on := true
+	if s.RunAsNonRoot {
+		ret.RunAsNonRoot = &on
+	}
+	if s.Privileged {
+		ret.Privileged = &on
+	}
+	if len(s.AddCapabilities) > 0 || len(s.DropCapabilities) > 0 {
+		ret.Capabilities = new(core.Capabilities)
+		for _, c := range s.AddCapabilities {
+			ret.Capabilities.Add = append(ret.Capabilities.Add, core.Capability(c))
+		}
+		for _, c := range s.DropCapabilities {
+			ret.Capabilities.Drop = append(ret.Capabilities.Drop, core.Capability(c))
+		}
+	}
+	if s.NoNewPrivileges {
+		off := false
+		ret.AllowPrivilegeEscalation = &off
+	}
+	if s.ReadOnlyRootFilesystem {
+		ret.ReadOnlyRootFilesystem = &on
+	}
+	if s.SeccompRuntimeDefault {
+		ret.SeccompProfile = &core.SeccompProfile{Type: core.SeccompProfileTypeRuntimeDefault}
+	}
+	ret.SELinuxOptions = s.SELinux
+	if (*ret == core.SecurityContext{}) {
+		return nil
+	}
+	return ret
+}
+
+func (s *Security) ToPodSecurityContext() *core.PodSecurityContext {
+	if len(s.SupplementalGroups) == 0 && s.FSGroup == nil {
+		return nil
+	}
+	return &core.PodSecurityContext{SupplementalGroups: s.SupplementalGroups, FSGroup: s.FSGroup}
+}
@@ -0,0 +1,126 @@
+package units
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	TargetSuffix = ".target"
+	SliceSuffix  = ".slice"
+	SliceSep     = "-"
+
+	SliceKey  = "Slice"
+	PartOfKey = "PartOf"
+	WantsKey  = "Wants"
+
+	DefaultDependenciesKey = "DefaultDependencies"
+)
+
+type PodUnits struct {
+	namespace, pod string
+	Names          []Name
+	Cgroup         Cgroup
+	Volumes        []*Volume
+}
+
+func NewPodUnits(om *meta.ObjectMeta, us []*Unit) *PodUnits {
+	p := &PodUnits{namespace: om.Namespace, pod: om.Name}
+	var apps []*Unit
+	for _, u := range us {
+		p.Names = append(p.Names, u.ID.Name())
+		if !u.ID.IsInit() {
+			apps = append(apps, u)
+		}
+	}
+	p.Cgroup = sumCgroup(apps)
+	return p
+}
+
+func TargetName(namespace, pod string) Name {
+	return Name(strings.Join([]string{Prefix, namespace, pod}, Sep) + TargetSuffix)
+}
+
+func SliceName(namespace, pod string) Name {
+	return Name(strings.Join(
+		[]string{Prefix, escapeSliceName(namespace), escapeSliceName(pod)},
+		SliceSep,
+	) + SliceSuffix)
+}
+
+func escapeSliceName(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c == '_' || c == '.' || c == ':' ||
+			('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+			b.WriteByte(c)
+			continue
+		}
+		_, _ = fmt.Fprintf(&b, `\x%02x`, c)
+	}
+	return b.String()
+}
+
+func (p *PodUnits) Namespace() string { return p.namespace }
+func (p *PodUnits) Pod() string       { return p.pod }
+
+func (p *PodUnits) TargetName() Name { return TargetName(p.namespace, p.pod) }
+func (p *PodUnits) SliceName() Name  { return SliceName(p.namespace, p.pod) }
+
+func (p *PodUnits) MarshalTarget() ([]byte, error) {
+	// Without the default dependencies the target is not ordered after the
+	// units it wants, the units are ordered after it instead, so a stop job
+	// of the target finishes only once all units have stopped.
+	entries := []*unit.UnitEntry{
+		{Name: "Description", Value: strings.Join([]string{Prefix, p.namespace, p.pod}, Sep)},
+		{Name: DefaultDependenciesKey, Value: "no"},
+	}
+	for _, name := range p.Names {
+		entries = append(entries, &unit.UnitEntry{Name: WantsKey, Value: string(name)})
+	}
+	return io.ReadAll(unit.SerializeSections([]*unit.UnitSection{
+		{Section: UnitSection, Entries: entries},
+		{
+			Section: "Install",
+			Entries: []*unit.UnitEntry{{Name: "WantedBy", Value: "multi-user.target"}},
+		},
+	}))
+}
+
+func (p *PodUnits) MarshalSlice() ([]byte, error) {
+	entries, _ := p.Cgroup.MarshalUnitEntries()
+	return io.ReadAll(unit.SerializeSections([]*unit.UnitSection{
+		{
+			Section: UnitSection,
+			Entries: []*unit.UnitEntry{
+				{Name: "Description", Value: strings.Join([]string{Prefix, p.namespace, p.pod}, Sep)},
+			},
+		},
+		{Section: "Slice", Entries: entries},
+	}))
+}
+
+func sumCgroup(us []*Unit) (ret Cgroup) {
+	sum := func(get func(g *Cgroup) *int64) *int64 {
+		var total int64
+		for _, u := range us {
+			n := get(&u.Cgroup)
+			if n == nil {
+				return nil
+			}
+			total += *n
+		}
+		if len(us) == 0 {
+			return nil
+		}
+		return &total
+	}
+	ret.CPUQuota = sum(func(g *Cgroup) *int64 { return g.CPUQuota })
+	ret.MemoryMax = sum(func(g *Cgroup) *int64 { return g.MemoryMax })
+	ret.TasksMax = sum(func(g *Cgroup) *int64 { return g.TasksMax })
+	return
+}
@@ -1,18 +1,44 @@
 package units
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/anqur/unitlet/pkg/errs"
 )
 
 func TestUnitEncoding(t *testing.T) {
 	wd := "/tmp"
 	user := int64(42)
+	grace := int64(60)
 	u := &Unit{
-		ID:      NewID("a", "b", "c"),
-		Cmd:     []string{"echo", "hello"},
-		PodUID:  "d",
-		Workdir: &wd,
-		User:    &user,
+		ID:       NewID("a", "b", "c"),
+		Cmd:      []string{"echo", "hello"},
+		PodUID:   "d",
+		Workdir:  &wd,
+		Security: Security{User: &user},
+		Restart:  core.RestartPolicyOnFailure,
+		Env: []core.EnvVar{
+			{Name: "A", Value: "1"},
+			{Name: "B", Value: `say "hi" 100% \ done` + "\n"},
+		},
+
+		StopTimeout: &grace,
+
+		Stdin:     true,
+		TTY:       true,
+		StdinPath: "/tmp/stdin",
 	}
 	data, err := u.Marshal()
 	if err != nil {
@@ -28,7 +54,15 @@ func TestUnitEncoding(t *testing.T) {
 		u.Cmd[1] != "hello" ||
 		u.PodUID != "d" ||
 		*u.Workdir != wd ||
-		*u.User != user {
+		*u.Security.User != user ||
+		u.Restart != core.RestartPolicyOnFailure ||
+		*u.StopTimeout != grace ||
+		len(u.Env) != 2 ||
+		u.Env[0].Value != "1" ||
+		u.Env[1].Value != `say "hi" 100% \ done`+"\n" ||
+		!u.Stdin ||
+		!u.TTY ||
+		u.StdinPath != "/tmp/stdin" {
 		t.Fatal(u)
 	}
 
@@ -42,3 +76,969 @@ func TestUnitEncoding(t *testing.T) {
 		t.Fatal(id)
 	}
 }
+
+func TestUnitEqual(t *testing.T) {
+	u := &Unit{
+		ID:        NewID("a", "b", "c"),
+		Cmd:       []string{"echo", "hello"},
+		PodUID:    "d",
+		Stdin:     true,
+		StdinPath: "/tmp/stdin",
+	}
+	v := *u
+	v.StdinPath = ""
+	if !u.Equal(&v) {
+		t.Fatal(v)
+	}
+
+	v.Cmd = []string{"echo", "world"}
+	if u.Equal(&v) {
+		t.Fatal(v)
+	}
+}
+
+func TestTTY(t *testing.T) {
+	u := &Unit{
+		ID:      NewID("a", "b", "c"),
+		Cmd:     []string{"sh"},
+		PodUID:  "d",
+		Stdin:   true,
+		TTY:     true,
+		TTYPath: "/tmp/tty",
+	}
+	data, err := u.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"StandardInput=tty", "TTYPath=/tmp/tty", "StandardOutput=journal"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Fatal(line, string(data))
+		}
+	}
+
+	v := new(Unit)
+	if err := v.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !v.Stdin || !v.TTY || v.TTYPath != "/tmp/tty" || v.StdinPath != "" {
+		t.Fatal(v)
+	}
+	v.TTYPath = ""
+	if !u.Equal(v) {
+		t.Fatal(v)
+	}
+}
+
+type fakeResources struct{}
+
+func (fakeResources) NodeName() string { return "node" }
+func (fakeResources) HostIP() string   { return "10.0.0.1" }
+
+func (fakeResources) GetConfigMap(name, _ string) (*core.ConfigMap, error) {
+	return &core.ConfigMap{Data: map[string]string{"k": name}}, nil
+}
+
+func (fakeResources) GetSecret(name, _ string) (*core.Secret, error) {
+	return &core.Secret{Data: map[string][]byte{"k": []byte(name)}}, nil
+}
+
+func TestFromPodEnv(t *testing.T) {
+	om := &meta.ObjectMeta{Namespace: "a", Name: "b", UID: "d"}
+	spec := &core.PodSpec{
+		Containers: []core.Container{{
+			Name:    "c",
+			Command: []string{"env"},
+			EnvFrom: []core.EnvFromSource{{
+				Prefix:       "CM_",
+				ConfigMapRef: &core.ConfigMapEnvSource{LocalObjectReference: core.LocalObjectReference{Name: "cm"}},
+			}},
+			Env: []core.EnvVar{
+				{Name: "CM_k", Value: "overridden"},
+				{Name: "POD_NAME", ValueFrom: &core.EnvVarSource{
+					FieldRef: &core.ObjectFieldSelector{FieldPath: FieldPodName},
+				}},
+				{Name: "HOST_IP", ValueFrom: &core.EnvVarSource{
+					FieldRef: &core.ObjectFieldSelector{FieldPath: FieldHostIP},
+				}},
+				{Name: "SECRET", ValueFrom: &core.EnvVarSource{
+					SecretKeyRef: &core.SecretKeySelector{
+						LocalObjectReference: core.LocalObjectReference{Name: "hunter2"},
+						Key:                  "k",
+					},
+				}},
+			},
+		}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := us[0].Env
+	if len(env) != 3 ||
+		env[0].Name != "CM_k" || env[0].Value != "overridden" ||
+		env[1].Value != "b" ||
+		env[2].Value != "10.0.0.1" {
+		t.Fatal(env)
+	}
+	creds := us[0].Credentials
+	if len(creds) != 1 ||
+		creds[0].ID != CredentialEnvPrefix+"SECRET" ||
+		string(creds[0].Data) != "hunter2" {
+		t.Fatal(creds)
+	}
+
+	us[0].CredentialsPath = "/secrets"
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, creds[0].Data) || bytes.Contains(data, []byte("Hash")) {
+		t.Fatal(string(data))
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Cmd) != 1 ||
+		u.Cmd[0] != "env" ||
+		len(u.Credentials) != 1 ||
+		u.Credentials[0].ID != creds[0].ID ||
+		u.CredentialsPath != "/secrets" {
+		t.Fatal(u)
+	}
+	if u.Equal(us[0]) {
+		t.Fatal(u)
+	}
+	u.Credentials[0].Data = []byte("hunter2")
+	if !u.Equal(us[0]) {
+		t.Fatal(u)
+	}
+	us[0].Credentials[0].Data = []byte("hunter3")
+	if u.Equal(us[0]) {
+		t.Fatal(u)
+	}
+}
+
+func TestCredentialEnvExec(t *testing.T) {
+	dir := t.TempDir()
+	value := "a b\n\nc\n\n"
+	if err := os.WriteFile(filepath.Join(dir, CredentialEnvPrefix+"SECRET"), []byte(value), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	script, _, _ := strings.Cut(strings.TrimPrefix(CredentialEnvExec, "/bin/sh -c '"), "' ")
+	script = strings.NewReplacer("$$", "$", `\n`, "\n").Replace(script)
+	cmd := exec.Command("/bin/sh", "-c", script, Prefix, "/bin/sh", "-c", `printf %s "$SECRET"`)
+	cmd.Env = []string{"CREDENTIALS_DIRECTORY=" + dir}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != value {
+		t.Fatalf("%q", out)
+	}
+}
+
+func TestCgroup(t *testing.T) {
+	g := NewCgroup(&core.ResourceRequirements{
+		Limits: core.ResourceList{
+			core.ResourceCPU:    resource.MustParse("1500m"),
+			core.ResourceMemory: resource.MustParse("1Gi"),
+			ResourcePIDs:        resource.MustParse("100"),
+		},
+		Requests: core.ResourceList{
+			core.ResourceCPU:    resource.MustParse("1"),
+			core.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	})
+	if *g.CPUQuota != 150 ||
+		*g.CPUWeight != 39 ||
+		*g.MemoryMax != 1<<30 ||
+		*g.MemoryLow != 512<<20 ||
+		*g.TasksMax != 100 {
+		t.Fatal(g)
+	}
+
+	u := &Unit{ID: NewID("a", "b", "c"), Cmd: []string{"true"}, PodUID: "d", Cgroup: g}
+	data, err := u.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := new(Unit)
+	if err := v.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if *v.Cgroup.CPUWeight != 39 || !v.Equal(u) {
+		t.Fatal(v)
+	}
+	r := v.ToContainer().Resources
+	if cpu := r.Limits[core.ResourceCPU]; cpu.MilliValue() != 1500 {
+		t.Fatal(r)
+	}
+	if cpu := r.Requests[core.ResourceCPU]; cpu.String() != "1" {
+		t.Fatal(r)
+	}
+	if mem := r.Requests[core.ResourceMemory]; mem.Value() != 512<<20 {
+		t.Fatal(r)
+	}
+	if pids := r.Limits[ResourcePIDs]; pids.Value() != 100 {
+		t.Fatal(r)
+	}
+}
+
+func TestFromPodInitContainers(t *testing.T) {
+	om := &meta.ObjectMeta{Namespace: "a", Name: "b", UID: "d"}
+	spec := &core.PodSpec{
+		InitContainers: []core.Container{
+			{Name: "i1", Command: []string{"true"}},
+			{Name: "i2", Command: []string{"true"}},
+		},
+		Containers: []core.Container{{Name: "c", Command: []string{"sleep", "1"}}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 3 ||
+		!us[0].ID.IsInit() || len(us[0].Requires) != 0 ||
+		!us[1].ID.IsInit() || us[1].Requires[0] != us[0].ID.Name() ||
+		us[2].ID.IsInit() || us[2].Requires[0] != us[1].ID.Name() ||
+		us[1].Restart != core.RestartPolicyOnFailure {
+		t.Fatal(us)
+	}
+
+	data, err := us[1].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !u.Equal(us[1]) || !bytes.Contains(data, []byte("Type="+TypeOneshot)) {
+		t.Fatal(string(data))
+	}
+
+	id, err := ParseName(u.ID.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.IsInit() || id.Container() != "i2" {
+		t.Fatal(id)
+	}
+}
+
+func TestPodUnits(t *testing.T) {
+	if name := SliceName("kube-system", "my-pod"); name != `unitlet-kube\x2dsystem-my\x2dpod.slice` {
+		t.Fatal(name)
+	}
+
+	max := int64(100)
+	us := []*Unit{
+		{ID: NewInitID("a", "b", "i")},
+		{ID: NewID("a", "b", "c1"), Cgroup: Cgroup{MemoryMax: &max, TasksMax: &max}},
+		{ID: NewID("a", "b", "c2"), Cgroup: Cgroup{MemoryMax: &max}},
+	}
+	p := NewPodUnits(&meta.ObjectMeta{Namespace: "a", Name: "b"}, us)
+	if len(p.Names) != 3 ||
+		*p.Cgroup.MemoryMax != 200 ||
+		p.Cgroup.TasksMax != nil ||
+		p.TargetName() != "unitlet.a.b.target" {
+		t.Fatal(p)
+	}
+
+	data, err := p.MarshalTarget()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("Wants=unitlet.a.b.c2.service")) ||
+		!bytes.Contains(data, []byte("DefaultDependencies=no")) {
+		t.Fatal(string(data))
+	}
+	us[1].Cmd = []string{"true"}
+	if data, err = us[1].Marshal(); err != nil || !bytes.Contains(data, []byte("After=unitlet.a.b.target")) {
+		t.Fatal(string(data), err)
+	}
+}
+
+func TestFromPodIsolation(t *testing.T) {
+	om := &meta.ObjectMeta{
+		Namespace:   "a",
+		Name:        "b",
+		UID:         "d",
+		Annotations: map[string]string{IsolationAnnotation: IsolationPod},
+	}
+	spec := &core.PodSpec{
+		HostIPC: true,
+		Containers: []core.Container{
+			{Name: "c1", Command: []string{"true"}},
+			{Name: "c2", Command: []string{"true"}},
+		},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !us[0].PrivateNetwork || us[0].PrivateIPC || !us[0].PrivateTmp ||
+		us[0].JoinsNamespaceOf != "" ||
+		us[1].JoinsNamespaceOf != us[0].ID.Name() {
+		t.Fatal(us)
+	}
+
+	data, err := us[1].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !u.Equal(us[1]) || !u.PrivateNetwork || u.JoinsNamespaceOf != us[0].ID.Name() {
+		t.Fatal(string(data))
+	}
+	if pod := u.ToPod("node", nil, nil, &core.PodStatus{}); pod.Spec.HostNetwork || !pod.Spec.HostIPC {
+		t.Fatal(pod.Spec)
+	}
+
+	spec.HostIPC = false
+	delete(om.Annotations, IsolationAnnotation)
+	if us, err = FromPod(om, spec, fakeResources{}); err != nil {
+		t.Fatal(err)
+	}
+	if pod := us[0].ToPod("node", nil, nil, &core.PodStatus{}); pod.Spec.HostNetwork || pod.Spec.HostIPC {
+		t.Fatal(pod.Spec)
+	}
+
+	om.Annotations[IsolationAnnotation] = IsolationPod
+	spec.InitContainers = []core.Container{{Name: "i", Command: []string{"true"}}}
+	if us, err = FromPod(om, spec, fakeResources{}); err != nil {
+		t.Fatal(err)
+	}
+	if lead := us[1].ID.Name(); us[0].JoinsNamespaceOf != lead || us[1].JoinsNamespaceOf != "" ||
+		us[2].JoinsNamespaceOf != lead || !us[0].PrivateNetwork {
+		t.Fatal(us)
+	}
+}
+
+func TestSetPodConditions(t *testing.T) {
+	startedAt := meta.NewTime(time.Unix(100, 0))
+	changedAt := meta.NewTime(time.Unix(200, 0))
+	status := &core.PodStatus{
+		StartTime: &startedAt,
+		InitContainerStatuses: []core.ContainerStatus{{
+			Name:  "init",
+			State: core.ContainerState{Terminated: &core.ContainerStateTerminated{FinishedAt: startedAt}},
+		}},
+		ContainerStatuses: []core.ContainerStatus{
+			{
+				Name:  "c1",
+				Ready: true,
+				State: core.ContainerState{Running: &core.ContainerStateRunning{StartedAt: startedAt}},
+			},
+			{
+				Name:  "c2",
+				State: core.ContainerState{Waiting: &core.ContainerStateWaiting{Reason: ReasonCrashLoopBackOff}},
+			},
+		},
+	}
+	SetPodConditions(status, changedAt)
+	want := map[core.PodConditionType]core.PodCondition{
+		core.PodScheduled:    {Status: core.ConditionTrue, LastTransitionTime: startedAt},
+		core.PodInitialized:  {Status: core.ConditionTrue, LastTransitionTime: startedAt},
+		core.ContainersReady: {Status: core.ConditionFalse, LastTransitionTime: changedAt},
+		core.PodReady:        {Status: core.ConditionFalse, LastTransitionTime: changedAt},
+	}
+	if len(status.Conditions) != len(want) {
+		t.Fatal(status.Conditions)
+	}
+	for _, c := range status.Conditions {
+		w := want[c.Type]
+		if c.Status != w.Status || !c.LastTransitionTime.Equal(&w.LastTransitionTime) {
+			t.Fatal(c)
+		}
+	}
+
+	status.ContainerStatuses[1].Ready = true
+	status.ContainerStatuses[1].State = core.ContainerState{Running: &core.ContainerStateRunning{StartedAt: changedAt}}
+	SetReadyConditions(status, changedAt)
+	for _, c := range status.Conditions {
+		if c.Status != core.ConditionTrue {
+			t.Fatal(c)
+		}
+	}
+}
+
+func TestFromPodLifecycle(t *testing.T) {
+	om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+	spec := &core.PodSpec{
+		Containers: []core.Container{{
+			Name:    "c",
+			Command: []string{"sleep", "infinity"},
+			Ports:   []core.ContainerPort{{Name: "http", ContainerPort: 8080}},
+			Lifecycle: &core.Lifecycle{
+				PostStart: &core.Handler{HTTPGet: &core.HTTPGetAction{
+					Path:        "/started",
+					Port:        intstr.FromString("http"),
+					HTTPHeaders: []core.HTTPHeader{{Name: "X-Hook", Value: "post start"}},
+				}},
+				PreStop: &core.Handler{Exec: &core.ExecAction{
+					Command: []string{"/bin/sh", "-c", `echo "bye $HOSTNAME" 100% && deregister`},
+				}},
+			},
+		}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		HookBin, HookHTTPCommand,
+		"-" + HookHeaderFlag, "X-Hook: post start",
+		"http://127.0.0.1:8080/started",
+	}
+	if strings.Join(us[0].PostStart, "\x00") != strings.Join(want, "\x00") {
+		t.Fatal(us[0].PostStart)
+	}
+
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !u.Equal(us[0]) ||
+		strings.Join(u.PreStop, "\x00") != strings.Join(spec.Containers[0].Lifecycle.PreStop.Exec.Command, "\x00") {
+		t.Fatal(string(data))
+	}
+	if l := u.ToContainer().Lifecycle; l == nil || l.PostStart.Exec == nil || l.PreStop.Exec == nil {
+		t.Fatal(l)
+	}
+	if !bytes.Contains(data, []byte(ExecStopKey+"="+PreStopGuard+" ")) {
+		t.Fatal(string(data))
+	}
+}
+
+func TestPreStopGuard(t *testing.T) {
+	script, _, _ := strings.Cut(strings.TrimPrefix(PreStopGuard, "/bin/sh -c '"), "' ")
+	script = strings.ReplaceAll(script, "$$", "$")
+	for _, tt := range []struct {
+		env []string
+		out string
+	}{
+		{[]string{"SERVICE_RESULT=success"}, "stop"},
+		{[]string{"SERVICE_RESULT=success", "EXIT_CODE=exited", "EXIT_STATUS=0"}, ""},
+		{[]string{"SERVICE_RESULT=exit-code", "EXIT_CODE=exited", "EXIT_STATUS=1"}, ""},
+	} {
+		cmd := exec.Command("/bin/sh", "-c", script, Prefix, "echo", "-n", "stop")
+		cmd.Env = tt.env
+		out, err := cmd.Output()
+		if err != nil || string(out) != tt.out {
+			t.Fatal(tt.env, string(out), err)
+		}
+	}
+}
+
+func TestActiveDeadline(t *testing.T) {
+	deadline := int64(600)
+	om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+	spec := &core.PodSpec{
+		ActiveDeadlineSeconds: &deadline,
+		Containers:            []core.Container{{Name: "c", Command: []string{"true"}}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(RuntimeMaxKey+"=600s")) || *u.ActiveDeadline != deadline {
+		t.Fatal(string(data))
+	}
+	left := int64(42)
+	us[0].RuntimeMax = &left
+	if data, err = us[0].Marshal(); err != nil || !bytes.Contains(data, []byte(RuntimeMaxKey+"=42s")) {
+		t.Fatal(string(data), err)
+	}
+	if !u.Equal(us[0]) {
+		t.Fatal(u, us[0])
+	}
+	spec.InitContainers = []core.Container{{Name: "i", Command: []string{"true"}}}
+	if us, err = FromPod(om, spec, fakeResources{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = us[0].Marshal(); err != nil ||
+		!bytes.Contains(data, []byte(TimeoutStartKey+"=600s")) || bytes.Contains(data, []byte(RuntimeMaxKey)) {
+		t.Fatal(string(data), err)
+	}
+	if pod := u.ToPod("node", nil, nil, &core.PodStatus{}); *pod.Spec.ActiveDeadlineSeconds != deadline {
+		t.Fatal(pod.Spec)
+	}
+
+	startTime := meta.NewTime(time.Unix(1000, 0))
+	for _, tt := range []struct {
+		startTime *meta.Time
+		deadline  *int64
+		now       time.Time
+		exceeded  bool
+	}{
+		{nil, &deadline, time.Unix(2000, 0), false},
+		{&startTime, nil, time.Unix(2000, 0), false},
+		{&startTime, &deadline, time.Unix(1599, 0), false},
+		{&startTime, &deadline, time.Unix(1600, 0), true},
+	} {
+		if exceeded := DeadlineExceeded(tt.startTime, tt.deadline, tt.now); exceeded != tt.exceeded {
+			t.Fatal(tt)
+		}
+	}
+
+	for _, tt := range []struct {
+		startTime *meta.Time
+		deadline  *int64
+		now       time.Time
+		left      *int64
+	}{
+		{nil, &deadline, time.Unix(2000, 0), &deadline},
+		{&startTime, nil, time.Unix(2000, 0), nil},
+		{&startTime, &deadline, time.Unix(1200, 0), &[]int64{400}[0]},
+		{&startTime, &deadline, time.Unix(2000, 0), &[]int64{1}[0]},
+	} {
+		left := RemainingDeadline(tt.startTime, tt.deadline, tt.now)
+		if (left == nil) != (tt.left == nil) || left != nil && *left != *tt.left {
+			t.Fatal(tt, left)
+		}
+	}
+
+	status := &core.PodStatus{Phase: core.PodRunning}
+	SetDeadlineExceeded(status)
+	if status.Phase != core.PodFailed || status.Reason != ReasonDeadlineExceeded {
+		t.Fatal(status)
+	}
+}
+
+func TestRemainAfterExit(t *testing.T) {
+	for restart, remain := range map[core.RestartPolicy]bool{
+		core.RestartPolicyAlways:    false,
+		core.RestartPolicyOnFailure: true,
+		core.RestartPolicyNever:     true,
+	} {
+		om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+		spec := &core.PodSpec{
+			RestartPolicy: restart,
+			Containers:    []core.Container{{Name: "c", Command: []string{"true"}}},
+		}
+		us, err := FromPod(om, spec, fakeResources{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := us[0].Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte(RemainAfterExitKey+"=yes")) != remain ||
+			!bytes.Contains(data, []byte(TypeKey+"="+TypeSimple)) {
+			t.Fatal(restart, string(data))
+		}
+	}
+}
+
+func TestFromPodVolumes(t *testing.T) {
+	mode := int32(0400)
+	size := resource.MustParse("64Mi")
+	dir := core.HostPathDirectory
+	user, fsGroup := int64(1000), int64(2000)
+	om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+	spec := &core.PodSpec{
+		SecurityContext: &core.PodSecurityContext{RunAsUser: &user, FSGroup: &fsGroup},
+		Volumes: []core.Volume{
+			{Name: "host", VolumeSource: core.VolumeSource{HostPath: &core.HostPathVolumeSource{Path: "/srv/data", Type: &dir}}},
+			{Name: "scratch", VolumeSource: core.VolumeSource{EmptyDir: &core.EmptyDirVolumeSource{
+				Medium:    core.StorageMediumMemory,
+				SizeLimit: &size,
+			}}},
+			{Name: "config", VolumeSource: core.VolumeSource{ConfigMap: &core.ConfigMapVolumeSource{
+				LocalObjectReference: core.LocalObjectReference{Name: "cm"},
+				Items:                []core.KeyToPath{{Key: "k", Path: "app/config.yaml", Mode: &mode}},
+			}}},
+			{Name: "token", VolumeSource: core.VolumeSource{Secret: &core.SecretVolumeSource{SecretName: "hunter2"}}},
+		},
+		Containers: []core.Container{{
+			Name:    "c",
+			Command: []string{"true"},
+			VolumeMounts: []core.VolumeMount{
+				{Name: "host", MountPath: "/data", ReadOnly: true},
+				{Name: "scratch", MountPath: "/scratch"},
+				{Name: "config", MountPath: "/etc/app/config.yaml", SubPath: "app/config.yaml"},
+				{Name: "token", MountPath: "/var/run/token"},
+			},
+		}},
+	}
+
+	vs, err := PodVolumes(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs) != 4 ||
+		vs[0].HostPath != "/srv/data" || vs[0].HostPathType != dir || vs[0].GID != nil ||
+		!vs[1].Memory || vs[1].SizeLimit.Cmp(size) != 0 || *vs[1].GID != fsGroup ||
+		vs[2].Files[0].Path != "app/config.yaml" || vs[2].Files[0].Mode != 0400|FSGroupFileMode || vs[2].Secret ||
+		string(vs[3].Files[0].Data) != "hunter2" || vs[3].Files[0].Mode != DefaultVolumeMode|FSGroupFileMode ||
+		!vs[3].Secret {
+		t.Fatal(vs)
+	}
+	if ps := ProjectedVolumes(vs); len(ps) != 2 || ps[0].Name != "config" {
+		t.Fatal(ps)
+	}
+
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	us[0].VolumesPath = "/var/lib/unitlet/.volumes/ns.pod"
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{
+		`BindReadOnlyPaths="/srv/data:/data"`,
+		`BindPaths="/var/lib/unitlet/.volumes/ns.pod/scratch:/scratch"`,
+		`BindReadOnlyPaths="/var/lib/unitlet/.volumes/ns.pod/config/..data/app/config.yaml:/etc/app/config.yaml"`,
+		`BindReadOnlyPaths="/var/lib/unitlet/.volumes/ns.pod/token:/var/run/token"`,
+	} {
+		if !bytes.Contains(data, []byte(entry)) {
+			t.Fatal(entry, string(data))
+		}
+	}
+
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !u.Equal(us[0]) || len(u.Mounts) != 4 || u.Mounts[2].SubPath != "app/config.yaml" || !u.Mounts[2].Projected ||
+		!u.MountsVolume("config") || u.MountsVolume("host") {
+		t.Fatal(u.Mounts)
+	}
+	if ms := u.ToContainer().VolumeMounts; len(ms) != 4 || ms[0].Name != "host" || !ms[0].ReadOnly {
+		t.Fatal(ms)
+	}
+}
+
+func TestSecurity(t *testing.T) {
+	podUser, containerUser, group, fsGroup := int64(1000), int64(1001), int64(2000), int64(4000)
+	nonRoot := true
+	om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+	spec := &core.PodSpec{
+		SecurityContext: &core.PodSecurityContext{
+			RunAsUser:          &podUser,
+			RunAsGroup:         &group,
+			RunAsNonRoot:       &nonRoot,
+			SupplementalGroups: []int64{3000},
+			FSGroup:            &fsGroup,
+		},
+		Volumes: []core.Volume{{Name: "config", VolumeSource: core.VolumeSource{
+			ConfigMap: &core.ConfigMapVolumeSource{LocalObjectReference: core.LocalObjectReference{Name: "cm"}},
+		}}},
+		Containers: []core.Container{{
+			Name:            "c",
+			Command:         []string{"true"},
+			SecurityContext: &core.SecurityContext{RunAsUser: &containerUser},
+		}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"User=1001", "Group=2000", "SupplementalGroups=3000 4000"} {
+		if !bytes.Contains(data, []byte(entry)) {
+			t.Fatal(entry, string(data))
+		}
+	}
+
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if !u.Equal(us[0]) {
+		t.Fatal(string(data))
+	}
+	sc := u.ToContainer().SecurityContext
+	if *sc.RunAsUser != containerUser || *sc.RunAsGroup != group || !*sc.RunAsNonRoot {
+		t.Fatal(sc)
+	}
+	psc := u.ToPod("node", nil, nil, new(core.PodStatus)).Spec.SecurityContext
+	if len(psc.SupplementalGroups) != 1 || psc.SupplementalGroups[0] != 3000 || *psc.FSGroup != fsGroup {
+		t.Fatal(psc)
+	}
+
+	spec.SecurityContext.SupplementalGroups = []int64{3000, fsGroup}
+	if us, err = FromPod(om, spec, fakeResources{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = us[0].Marshal(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("SupplementalGroups=3000 4000\n")) {
+		t.Fatal(string(data))
+	}
+	u = new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	psc = u.ToPod("node", nil, nil, new(core.PodStatus)).Spec.SecurityContext
+	if !u.Equal(us[0]) || len(psc.SupplementalGroups) != 2 || psc.SupplementalGroups[1] != fsGroup {
+		t.Fatal(psc)
+	}
+
+	vs, err := PodVolumes(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *vs[0].GID != fsGroup || vs[0].Files[0].Mode != DefaultVolumeMode|FSGroupFileMode {
+		t.Fatal(vs[0])
+	}
+
+	spec.SecurityContext.RunAsUser = nil
+	spec.Containers[0].SecurityContext = nil
+	if _, err := FromPod(om, spec, fakeResources{}); !errors.Is(err, errs.ErrRunAsRoot) {
+		t.Fatal(err)
+	}
+}
+
+func TestSandboxing(t *testing.T) {
+	off, on := false, true
+	om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+	spec := &core.PodSpec{
+		SecurityContext: &core.PodSecurityContext{
+			SeccompProfile: &core.SeccompProfile{Type: core.SeccompProfileTypeRuntimeDefault},
+		},
+		Containers: []core.Container{
+			{
+				Name:    "hardened",
+				Command: []string{"true"},
+				SecurityContext: &core.SecurityContext{
+					Capabilities: &core.Capabilities{
+						Add:  []core.Capability{"NET_ADMIN"},
+						Drop: []core.Capability{"ALL"},
+					},
+					AllowPrivilegeEscalation: &off,
+					ReadOnlyRootFilesystem:   &on,
+				},
+			},
+			{
+				Name:            "privileged",
+				Command:         []string{"true"},
+				SecurityContext: &core.SecurityContext{Privileged: &on},
+			},
+		},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{
+		"CapabilityBoundingSet=CAP_NET_ADMIN\n",
+		"AmbientCapabilities=CAP_NET_ADMIN\n",
+		"NoNewPrivileges=yes",
+		"ProtectSystem=strict",
+		"ProtectKernelTunables=yes",
+		"SystemCallFilter=@system-service",
+	} {
+		if !bytes.Contains(data, []byte(entry)) {
+			t.Fatal(entry, string(data))
+		}
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	sc := u.ToContainer().SecurityContext
+	if !u.Equal(us[0]) ||
+		sc.Capabilities.Add[0] != "NET_ADMIN" || sc.Capabilities.Drop[0] != "ALL" ||
+		*sc.AllowPrivilegeEscalation || !*sc.ReadOnlyRootFilesystem ||
+		sc.SeccompProfile.Type != core.SeccompProfileTypeRuntimeDefault {
+		t.Fatal(sc)
+	}
+
+	data, err = us[1].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(CapabilityBoundingSetKey)) || bytes.Contains(data, []byte("ProtectKernelTunables")) ||
+		bytes.Contains(data, []byte(SystemCallFilterKey)) {
+		t.Fatal(string(data))
+	}
+	u = new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	if sc := u.ToContainer().SecurityContext; !u.Equal(us[1]) || !*sc.Privileged ||
+		sc.SeccompProfile.Type != core.SeccompProfileTypeRuntimeDefault {
+		t.Fatal(sc)
+	}
+}
+
+func TestMACOptions(t *testing.T) {
+	om := &meta.ObjectMeta{
+		Namespace: "ns",
+		Name:      "pod",
+		UID:       "uid",
+		Annotations: map[string]string{
+			core.AppArmorBetaContainerAnnotationKeyPrefix + "c": core.AppArmorBetaProfileNamePrefix + "unitlet-app",
+		},
+	}
+	spec := &core.PodSpec{
+		SecurityContext: &core.PodSecurityContext{SELinuxOptions: &core.SELinuxOptions{Level: "s0:c1,c2"}},
+		Containers: []core.Container{{
+			Name:    "c",
+			Command: []string{"true"},
+			SecurityContext: &core.SecurityContext{
+				SELinuxOptions: &core.SELinuxOptions{Type: "app_t", Level: "s0:c3,c4"},
+			},
+		}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{
+		"SELinuxContext=system_u:system_r:app_t:s0:c3,c4",
+		"AppArmorProfile=unitlet-app",
+	} {
+		if !bytes.Contains(data, []byte(entry)) {
+			t.Fatal(entry, string(data))
+		}
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	sc := u.ToContainer().SecurityContext
+	if !u.Equal(us[0]) || u.Security.AppArmorProfile != "unitlet-app" || sc.SELinuxOptions.User != "" ||
+		sc.SELinuxOptions.Type != "app_t" || sc.SELinuxOptions.Level != "s0:c3,c4" {
+		t.Fatal(sc)
+	}
+
+	spec.SecurityContext = nil
+	spec.Containers[0].SecurityContext.SELinuxOptions = &core.SELinuxOptions{Type: "spc_t"}
+	if us, err = FromPod(om, spec, fakeResources{}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = us[0].Marshal(); err != nil || !bytes.Contains(data, []byte("SELinuxContext=system_u:system_r:spc_t:s0\n")) {
+		t.Fatal(string(data), err)
+	}
+	u = new(Unit)
+	if err := u.Unmarshal(data); err != nil || !u.Equal(us[0]) || u.Security.SELinux.Level != "" {
+		t.Fatal(u, err)
+	}
+}
+
+func TestMACOptionsInjection(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		profile string
+		selinux *core.SELinuxOptions
+	}{
+		{"profile newline", "app\nExecStartPre=/bin/evil", nil},
+		{"profile space", "app evil", nil},
+		{"selinux type", "", &core.SELinuxOptions{Type: "app_t\nUser=0", Level: "s0:c1,c2"}},
+		{"selinux user", "", &core.SELinuxOptions{User: "system_u:evil", Level: "s0:c1,c2"}},
+		{"selinux level", "", &core.SELinuxOptions{Level: "s0:c1 c2"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			om := &meta.ObjectMeta{Namespace: "ns", Name: "pod", UID: "uid"}
+			if tt.profile != "" {
+				om.Annotations = map[string]string{
+					core.AppArmorBetaContainerAnnotationKeyPrefix + "c": core.AppArmorBetaProfileNamePrefix + tt.profile,
+				}
+			}
+			spec := &core.PodSpec{Containers: []core.Container{{
+				Name:            "c",
+				Command:         []string{"true"},
+				SecurityContext: &core.SecurityContext{SELinuxOptions: tt.selinux},
+			}}}
+			if _, err := FromPod(om, spec, fakeResources{}); !errors.Is(err, errs.ErrBadMACOption) {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestProbes(t *testing.T) {
+	om := &meta.ObjectMeta{Namespace: "a", Name: "b", UID: "d"}
+	spec := &core.PodSpec{
+		Containers: []core.Container{{
+			Name:    "c",
+			Command: []string{"true"},
+			Ports:   []core.ContainerPort{{Name: "http", ContainerPort: 8080}},
+			ReadinessProbe: &core.Probe{
+				Handler: core.Handler{HTTPGet: &core.HTTPGetAction{
+					Path: "/healthz?a=%25&b=$x",
+					Port: intstr.FromString("http"),
+				}},
+				PeriodSeconds: 5,
+			},
+			LivenessProbe: &core.Probe{
+				Handler: core.Handler{TCPSocket: &core.TCPSocketAction{Port: intstr.FromInt(9090)}},
+			},
+		}},
+	}
+	us, err := FromPod(om, spec, fakeResources{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := us[0].Probes.Readiness.HTTPGet.Port; port.Type != intstr.Int || port.IntValue() != 8080 {
+		t.Fatal(port)
+	}
+	if spec.Containers[0].ReadinessProbe.HTTPGet.Port.Type != intstr.String {
+		t.Fatal(spec.Containers[0].ReadinessProbe)
+	}
+
+	data, err := us[0].Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := new(Unit)
+	if err := u.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	c := u.ToContainer()
+	if !u.Equal(us[0]) ||
+		u.Probes.Startup != nil ||
+		c.ReadinessProbe.HTTPGet.Path != "/healthz?a=%25&b=$x" ||
+		c.ReadinessProbe.PeriodSeconds != 5 ||
+		c.LivenessProbe.TCPSocket.Port.IntValue() != 9090 {
+		t.Fatal(string(data))
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	zero := int64(0)
+	u := &Unit{ID: NewID("a", "b", "c"), Cmd: []string{"true"}, PodUID: "d", StopTimeout: &zero}
+	data, err := u.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(TimeoutStopKey+"=1s\n")) {
+		t.Fatal(string(data))
+	}
+}
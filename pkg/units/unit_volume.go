@@ -0,0 +1,279 @@
+package units
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	BindPathsKey         = "BindPaths"
+	BindReadOnlyPathsKey = "BindReadOnlyPaths"
+	VolumeMountKey       = "VolumeMount"
+
+	VolumeRestartAnnotation = Prefix + "/restart-on-volume-change"
+
+	ProjectedDataDir = "..data"
+
+	DefaultVolumeMode = 0644
+	FSGroupFileMode   = 0440
+)
+
+type (
+	Volume struct {
+		Name         string
+		HostPath     string
+		HostPathType core.HostPathType
+		Memory       bool
+		SizeLimit    *resource.Quantity
+		Projected    bool
+		Secret       bool
+		Files        []VolumeFile
+		GID          *int64
+	}
+
+	VolumeFile struct {
+		Path string
+		Data []byte
+		Mode os.FileMode
+	}
+
+	Mount struct {
+		Volume    string
+		HostPath  string
+		SubPath   string
+		Target    string
+		ReadOnly  bool
+		Projected bool
+	}
+)
+
+func PodVolumes(om *meta.ObjectMeta, spec *core.PodSpec, res Resources) (ret []*Volume, err error) {
+	for i := range spec.Volumes {
+		v := &spec.Volumes[i]
+		switch src := v.VolumeSource; {
+		case src.HostPath != nil:
+			t := core.HostPathUnset
+			if src.HostPath.Type != nil {
+				t = *src.HostPath.Type
+			}
+			ret = append(ret, &Volume{Name: v.Name, HostPath: src.HostPath.Path, HostPathType: t})
+
+		case src.EmptyDir != nil:
+			ret = append(ret, &Volume{
+				Name:      v.Name,
+				Memory:    src.EmptyDir.Medium == core.StorageMediumMemory,
+				SizeLimit: src.EmptyDir.SizeLimit,
+			})
+
+		case src.ConfigMap != nil:
+			cm, err := res.GetConfigMap(src.ConfigMap.Name, om.Namespace)
+			if err != nil {
+				if isOptional(src.ConfigMap.Optional, err) {
+					ret = append(ret, &Volume{Name: v.Name, Projected: true})
+					continue
+				}
+				return nil, err
+			}
+			data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+			for k, v := range cm.Data {
+				data[k] = []byte(v)
+			}
+			for k, v := range cm.BinaryData {
+				data[k] = v
+			}
+			ret = append(ret, &Volume{
+				Name:      v.Name,
+				Projected: true,
+				Files:     volumeFiles(data, src.ConfigMap.Items, src.ConfigMap.DefaultMode),
+			})
+
+		case src.Secret != nil:
+			s, err := res.GetSecret(src.Secret.SecretName, om.Namespace)
+			if err != nil {
+				if isOptional(src.Secret.Optional, err) {
+					ret = append(ret, &Volume{Name: v.Name, Projected: true, Secret: true})
+					continue
+				}
+				return nil, err
+			}
+			ret = append(ret, &Volume{
+				Name:      v.Name,
+				Projected: true,
+				Secret:    true,
+				Files:     volumeFiles(s.Data, src.Secret.Items, src.Secret.DefaultMode),
+			})
+		}
+	}
+
+	sc := spec.SecurityContext
+	if sc == nil {
+		return
+	}
+	if sc.FSGroup != nil {
+		for _, v := range ret {
+			if v.HostPath != "" {
+				continue
+			}
+			v.GID = sc.FSGroup
+			for i := range v.Files {
+				v.Files[i].Mode |= FSGroupFileMode
+			}
+		}
+	}
+	return
+}
+
+func ProjectedVolumes(vs []*Volume) (ret []*Volume) {
+	for _, v := range vs {
+		if v.Projected {
+			ret = append(ret, v)
+		}
+	}
+	return
+}
+
+func (u *Unit) MountsVolume(name string) bool {
+	for _, m := range u.Mounts {
+		if m.HostPath == "" && m.Volume == name {
+			return true
+		}
+	}
+	return false
+}
+
+func volumeFiles(data map[string][]byte, items []core.KeyToPath, defaultMode *int32) (ret []VolumeFile) {
+	mode := os.FileMode(DefaultVolumeMode)
+	if defaultMode != nil {
+		mode = os.FileMode(*defaultMode)
+	}
+	if len(items) == 0 {
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ret = append(ret, VolumeFile{Path: k, Data: data[k], Mode: mode})
+		}
+		return
+	}
+	for _, item := range items {
+		v, ok := data[item.Key]
+		if !ok {
+			continue
+		}
+		f := VolumeFile{Path: item.Path, Data: v, Mode: mode}
+		if item.Mode != nil {
+			f.Mode = os.FileMode(*item.Mode)
+		}
+		ret = append(ret, f)
+	}
+	return
+}
+
+func containerMounts(spec *core.PodSpec, c *core.Container) (ret []Mount) {
+	for _, vm := range c.VolumeMounts {
+		for i := range spec.Volumes {
+			v := &spec.Volumes[i]
+			if v.Name != vm.Name {
+				continue
+			}
+			m := Mount{
+				Volume:   v.Name,
+				SubPath:  vm.SubPath,
+				Target:   vm.MountPath,
+				ReadOnly: vm.ReadOnly,
+			}
+			switch src := v.VolumeSource; {
+			case src.HostPath != nil:
+				m.HostPath = src.HostPath.Path
+			case src.ConfigMap != nil, src.Secret != nil:
+				m.ReadOnly = true
+				m.Projected = true
+			case src.EmptyDir != nil:
+			default:
+				continue
+			}
+			ret = append(ret, m)
+		}
+	}
+	return
+}
+
+func (u *Unit) marshalMounts() (service, k8s []*unit.UnitEntry) {
+	for _, m := range u.Mounts {
+		source := filepath.Join(u.VolumesPath, m.Volume, m.SubPath)
+		switch {
+		case m.HostPath != "":
+			source = filepath.Join(m.HostPath, m.SubPath)
+		case m.Projected && m.SubPath != "":
+			source = filepath.Join(u.VolumesPath, m.Volume, ProjectedDataDir, m.SubPath)
+		}
+		key := BindPathsKey
+		if m.ReadOnly {
+			key = BindReadOnlyPathsKey
+		}
+		service = append(service, &unit.UnitEntry{
+			Name:  key,
+			Value: quoteWord(escapeColon(source) + ":" + escapeColon(m.Target)),
+		})
+		k8s = append(k8s, &unit.UnitEntry{
+			Name: VolumeMountKey,
+			Value: quoteCommand([]string{
+				m.Volume, m.HostPath, m.SubPath, m.Target,
+				strconv.FormatBool(m.ReadOnly), strconv.FormatBool(m.Projected),
+			}),
+		})
+	}
+	return
+}
+
+func (u *Unit) unmarshalMount(e *unit.UnitEntry) error {
+	fields, err := unquoteCommand(e.Value)
+	if err != nil {
+		return err
+	}
+	if len(fields) != 6 {
+		return fmt.Errorf("bad volume mount %q", e.Value)
+	}
+	readOnly, err := strconv.ParseBool(fields[4])
+	if err != nil {
+		return err
+	}
+	projected, err := strconv.ParseBool(fields[5])
+	if err != nil {
+		return err
+	}
+	u.Mounts = append(u.Mounts, Mount{
+		Volume:    fields[0],
+		HostPath:  fields[1],
+		SubPath:   fields[2],
+		Target:    fields[3],
+		ReadOnly:  readOnly,
+		Projected: projected,
+	})
+	return nil
+}
+
+func (u *Unit) toVolumeMounts() (ret []core.VolumeMount) {
+	for _, m := range u.Mounts {
+		ret = append(ret, core.VolumeMount{
+			Name:      m.Volume,
+			SubPath:   m.SubPath,
+			MountPath: m.Target,
+			ReadOnly:  m.ReadOnly,
+		})
+	}
+	return
+}
+
+func escapeColon(s string) string { return strings.ReplaceAll(s, ":", `\:`) }